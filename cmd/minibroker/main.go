@@ -18,21 +18,31 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/kubernetes-sigs/minibroker/pkg/auth"
 	"github.com/kubernetes-sigs/minibroker/pkg/broker"
 	"github.com/kubernetes-sigs/minibroker/pkg/kubernetes"
+	"github.com/kubernetes-sigs/minibroker/pkg/minibroker"
+	"github.com/kubernetes-sigs/minibroker/pkg/tlsutil"
 	"github.com/pmorie/osb-broker-lib/pkg/metrics"
 	prom "github.com/prometheus/client_golang/prometheus"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	klog "k8s.io/klog/v2"
 
-	"github.com/pmorie/osb-broker-lib/pkg/rest"
+	osbrest "github.com/pmorie/osb-broker-lib/pkg/rest"
 	"github.com/pmorie/osb-broker-lib/pkg/server"
 )
 
@@ -47,6 +57,17 @@ var options struct {
 	Port    int
 	TLSCert string
 	TLSKey  string
+
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSClientCAFile   string
+	TLSMinVersion     string
+	TLSCipherSuites   string
+	TLSReloadInterval time.Duration
+
+	AuthCredentialsPath string
+	AuthSecret          string
+	AuthReloadInterval  time.Duration
 }
 
 func main() {
@@ -58,16 +79,42 @@ func main() {
 		"base-64 encoded PEM block to use as the certificate for TLS. If '--tlsCert' is used, then '--tlsKey' must also be used. If '--tlsCert' is not used, then TLS will not be used.")
 	flag.StringVar(&options.TLSKey, "tlsKey", "",
 		"base-64 encoded PEM block to use as the private key matching the TLS certificate. If '--tlsKey' is used, then '--tlsCert' must also be used")
+	flag.StringVar(&options.TLSCertFile, "tlsCertFile", "",
+		"The path to the PEM file with the certificate for TLS. The file is reloaded when it changes. If '--tlsCertFile' is used, then '--tlsKeyFile' must also be used. Cannot be used with '--tlsCert'")
+	flag.StringVar(&options.TLSKeyFile, "tlsKeyFile", "",
+		"The path to the PEM file with the private key matching the TLS certificate. The file is reloaded when it changes. If '--tlsKeyFile' is used, then '--tlsCertFile' must also be used")
+	flag.StringVar(&options.TLSClientCAFile, "tlsClientCAFile", "",
+		"The path to the PEM bundle of the CAs used to verify client certificates. If set, the broker API requires clients to present a valid certificate. Requires '--tlsCertFile' and '--tlsKeyFile'")
+	flag.StringVar(&options.TLSMinVersion, "tlsMinVersion", "1.2",
+		"The minimum TLS version accepted: 1.0, 1.1, 1.2 or 1.3")
+	flag.StringVar(&options.TLSCipherSuites, "tlsCipherSuites", "",
+		"A comma-separated list of the IANA names of the cipher suites accepted for TLS 1.0 to 1.2. If not set, the Go defaults are used")
+	flag.DurationVar(&options.TLSReloadInterval, "tlsReloadInterval", 10*time.Second,
+		"The interval for checking the TLS files for changes")
 	flag.StringVar(&options.CatalogPath, "catalogPath", "",
-		"The path to the catalog")
+		"The path to the YAML or JSON file with the curated catalog. If set, only the charts listed in the file are offered, with the service and plan details set in the file")
 	flag.StringVar(&options.HelmRepoURL, "helmUrl", "",
 		"The url to the helm repo")
 	flag.StringVar(&options.DefaultNamespace, "defaultNamespace", "",
 		"The default namespace for brokers when the request doesn't specify")
 	flag.StringVar(&options.ProvisioningSettingsPath, "provisioningSettings", "",
 		"The path to the YAML file where the optional provisioning settings are stored")
+	flag.StringVar(&options.CredentialMappingsPath, "credentialMappings", "",
+		"The path to the YAML or JSON file with the credential mappings declaring how the credentials of charts without a built-in provider are built")
+	flag.StringVar(&options.ProviderPluginDir, "providerPluginDir", "",
+		"The directory with the provider plugins: executables named after the charts they produce the credentials of, reading the binding request as JSON from stdin and writing the credentials as JSON to stdout")
+	flag.DurationVar(&options.ProviderPluginTimeout, "providerPluginTimeout", minibroker.DefaultPluginTimeout,
+		"The time provider plugins have to produce the credentials")
 	flag.StringVar(&options.ClusterDomain, "clusterDomain", "",
 		"The k8s cluster domain - if not set, Minibroker infers from /etc/resolv.conf")
+	flag.StringVar(&options.AuditLogPath, "auditLog", "",
+		"The path to the file where the audit trail of the requested operations is appended as JSON lines. Use '-' for stdout. If not set, no audit trail is recorded")
+	flag.StringVar(&options.AuthCredentialsPath, "authCredentials", "",
+		"The path to the YAML file with the basic auth username and password and the bearer tokens accepted by the broker API. Cannot be used with '--authSecret'")
+	flag.StringVar(&options.AuthSecret, "authSecret", "",
+		"The Kubernetes Secret, as 'name' in the config namespace or 'namespace/name', with the basic auth username and password and the bearer tokens accepted by the broker API. Cannot be used with '--authCredentials'")
+	flag.DurationVar(&options.AuthReloadInterval, "authReloadInterval", 30*time.Second,
+		"The interval for reloading the broker API credentials")
 	flag.Parse()
 
 	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
@@ -121,6 +168,23 @@ func runWithContext(ctx context.Context) error {
 		err := fmt.Errorf("failed to start Minibroker: to use TLS, both --tlsCert and --tlsKey must be used")
 		return err
 	}
+	if (options.TLSCertFile != "" || options.TLSKeyFile != "") &&
+		(options.TLSCertFile == "" || options.TLSKeyFile == "") {
+		err := fmt.Errorf("failed to start Minibroker: to use TLS, both --tlsCertFile and --tlsKeyFile must be used")
+		return err
+	}
+	if options.TLSCert != "" && options.TLSCertFile != "" {
+		err := fmt.Errorf("failed to start Minibroker: --tlsCert and --tlsCertFile cannot be used together")
+		return err
+	}
+	if options.TLSClientCAFile != "" && options.TLSCertFile == "" {
+		err := fmt.Errorf("failed to start Minibroker: --tlsClientCAFile requires --tlsCertFile and --tlsKeyFile")
+		return err
+	}
+	if options.AuthCredentialsPath != "" && options.AuthSecret != "" {
+		err := fmt.Errorf("failed to start Minibroker: --authCredentials and --authSecret cannot be used together")
+		return err
+	}
 
 	addr := ":" + strconv.Itoa(options.Port)
 
@@ -136,25 +200,136 @@ func runWithContext(ctx context.Context) error {
 	osbMetrics := metrics.New()
 	reg.MustRegister(osbMetrics)
 
-	api, err := rest.NewAPISurface(b, osbMetrics)
+	api, err := osbrest.NewAPISurface(b, osbMetrics)
 	if err != nil {
 		return err
 	}
 
 	s := server.New(api, reg)
 
+	tlsConfig, err := newTLSConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start Minibroker: %v", err)
+	}
+	if options.TLSClientCAFile != "" {
+		s.Router.Use(auth.RequireClientCertificate)
+	}
+
+	authenticator, err := newAuthenticator()
+	if err != nil {
+		return fmt.Errorf("failed to start Minibroker: %v", err)
+	}
+	if authenticator != nil {
+		s.Router.Use(authenticator.Middleware)
+		go authenticator.Run(ctx, options.AuthReloadInterval)
+	} else if options.TLSClientCAFile == "" {
+		klog.Warningf("the broker API is not authenticated: use --authCredentials, --authSecret or --tlsClientCAFile to enable authentication")
+	}
+	s.Router.Use(b.CatalogMiddleware)
+
 	klog.V(1).Infof("starting broker!")
 
-	if options.TLSCert == "" && options.TLSKey == "" {
-		err = s.Run(ctx, addr)
-	} else {
-		err = s.RunTLS(ctx, addr, options.TLSCert, options.TLSKey)
+	return serve(ctx, addr, s.Router, tlsConfig)
+}
+
+// newTLSConfig creates the TLS configuration for the broker API from the CLI options. It returns
+// nil when TLS is not enabled. The certificate files are reloaded in the background until the
+// context is done.
+func newTLSConfig(ctx context.Context) (*tls.Config, error) {
+	if options.TLSCert == "" && options.TLSCertFile == "" {
+		return nil, nil
+	}
+	minVersion, err := tlsutil.ParseVersion(options.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := tlsutil.ParseCipherSuites(strings.Split(options.TLSCipherSuites, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	if options.TLSCertFile != "" {
+		reloader, err := tlsutil.NewReloader(options.TLSCertFile, options.TLSKeyFile, options.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		go reloader.Run(ctx, options.TLSReloadInterval)
+		return tlsutil.NewServerConfig(reloader, minVersion, cipherSuites), nil
+	}
+
+	cert, err := base64.StdEncoding.DecodeString(options.TLSCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode --tlsCert: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(options.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode --tlsKey: %v", err)
+	}
+	certificate, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:   minVersion,
+		Certificates: []tls.Certificate{certificate},
+	}
+	if len(cipherSuites) > 0 {
+		config.CipherSuites = cipherSuites
+	}
+	return config, nil
+}
+
+// serve serves the handler on addr until the context is done. TLS is used when tlsConfig is set.
+func serve(ctx context.Context, addr string, handler http.Handler, tlsConfig *tls.Config) error {
+	klog.Infof("starting server on %s", addr)
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	go func() {
+		<-ctx.Done()
+		c, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if srv.Shutdown(c) != nil {
+			srv.Close()
+		}
+	}()
+	if tlsConfig != nil {
+		return srv.ListenAndServeTLS("", "")
+	}
+	return srv.ListenAndServe()
+}
+
+// newAuthenticator creates the authenticator for the broker API from the CLI options. It returns nil
+// when authentication is not enabled.
+func newAuthenticator() (*auth.Authenticator, error) {
+	var source auth.Source
+	switch {
+	case options.AuthCredentialsPath != "":
+		source = auth.NewFileSource(options.AuthCredentialsPath)
+	case options.AuthSecret != "":
+		namespace, name := options.ConfigNamespace, options.AuthSecret
+		if parts := strings.SplitN(options.AuthSecret, "/", 2); len(parts) == 2 {
+			namespace, name = parts[0], parts[1]
+		}
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, err
+		}
+		client, err := clientset.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		source = auth.NewSecretSource(client.CoreV1(), namespace, name)
+	default:
+		return nil, nil
 	}
-	return err
+	return auth.NewAuthenticator(source)
 }
 
 func cancelOnInterrupt(ctx context.Context, f context.CancelFunc) {
-	term := make(chan os.Signal)
+	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 
 	for {
@@ -0,0 +1,143 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/kubernetes-sigs/minibroker/pkg/audit"
+)
+
+var _ = Describe("Audit", func() {
+	Describe("ParseIdentity", func() {
+		It("returns nil when there is no identity", func() {
+			identity, err := audit.ParseIdentity(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(identity).To(BeNil())
+		})
+
+		It("parses Kubernetes identities", func() {
+			identity, err := audit.ParseIdentity(&osb.OriginatingIdentity{
+				Platform: osb.PlatformKubernetes,
+				Value:    `{"username":"jane","uid":"123","groups":["admins"],"extra":{"scopes":["a"]}}`,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(identity).To(Equal(&audit.Identity{
+				Platform: osb.PlatformKubernetes,
+				Username: "jane",
+				UID:      "123",
+				Groups:   []string{"admins"},
+				Extra:    map[string]interface{}{"scopes": []string{"a"}},
+			}))
+			Expect(identity.String()).To(Equal("kubernetes:jane"))
+		})
+
+		It("parses Cloud Foundry identities", func() {
+			identity, err := audit.ParseIdentity(&osb.OriginatingIdentity{
+				Platform: osb.PlatformCloudFoundry,
+				Value:    `{"user_id":"683ea748-3092-4ff4-b656-39cacc4d5360","org":"dev"}`,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(identity).To(Equal(&audit.Identity{
+				Platform: osb.PlatformCloudFoundry,
+				Username: "683ea748-3092-4ff4-b656-39cacc4d5360",
+				Extra:    map[string]interface{}{"org": "dev"},
+			}))
+		})
+
+		It("fails for malformed identities", func() {
+			_, err := audit.ParseIdentity(&osb.OriginatingIdentity{
+				Platform: osb.PlatformCloudFoundry,
+				Value:    `{"org":"dev"}`,
+			})
+			Expect(err).To(MatchError("failed to parse originating identity: user_id key was not found in cloud foundry object"))
+		})
+	})
+
+	Describe("JSONLinesTrail", func() {
+		fakeTimeNow := func() time.Time {
+			return time.Date(2001, time.September, 9, 1, 46, 40, 0, time.UTC)
+		}
+
+		It("writes one JSON document per event", func() {
+			var buf bytes.Buffer
+			trail := audit.NewJSONLinesTrail(&buf, fakeTimeNow)
+
+			err := trail.Record(audit.Event{
+				Action:     audit.ActionProvision,
+				Outcome:    audit.OutcomeInProgress,
+				InstanceID: "instance",
+				ServiceID:  "redis",
+				Identity:   &audit.Identity{Platform: osb.PlatformKubernetes, Username: "jane"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = trail.Record(audit.Event{
+				Action:     audit.ActionUnbind,
+				Outcome:    audit.OutcomeFailed,
+				InstanceID: "instance",
+				BindingID:  "binding",
+				Error:      "boom",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(buf.String()).To(Equal(
+				`{"time":"2001-09-09T01:46:40Z","action":"provision","outcome":"in progress","instance_id":"instance","service_id":"redis","identity":{"platform":"kubernetes","username":"jane"}}` + "\n" +
+					`{"time":"2001-09-09T01:46:40Z","action":"unbind","outcome":"failed","instance_id":"instance","binding_id":"binding","error":"boom"}` + "\n",
+			))
+		})
+
+		It("fails when the writer fails", func() {
+			trail := audit.NewJSONLinesTrail(failingWriter{}, fakeTimeNow)
+			err := trail.Record(audit.Event{Action: audit.ActionBind})
+			Expect(err).To(MatchError("failed to record audit event: failed write"))
+		})
+	})
+
+	Describe("NewFileTrail", func() {
+		It("appends to an existing file", func() {
+			dir, err := ioutil.TempDir("", "audit")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "audit.log")
+			Expect(ioutil.WriteFile(path, []byte("existing\n"), 0600)).To(Succeed())
+
+			trail, err := audit.NewFileTrail(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(trail.Record(audit.Event{Action: audit.ActionBind})).To(Succeed())
+
+			data, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(HavePrefix("existing\n{"))
+		})
+	})
+})
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("failed write")
+}
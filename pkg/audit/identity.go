@@ -0,0 +1,83 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"fmt"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	osbbroker "github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// Identity is the platform user that originated a request, decoded from the
+// X-Broker-API-Originating-Identity header.
+type Identity struct {
+	Platform string `json:"platform"`
+	// Username is the Kubernetes username or the Cloud Foundry user ID.
+	Username string                 `json:"username,omitempty"`
+	UID      string                 `json:"uid,omitempty"`
+	Groups   []string               `json:"groups,omitempty"`
+	Extra    map[string]interface{} `json:"extra,omitempty"`
+}
+
+// ParseIdentity decodes the originating identity of a request for the Kubernetes and Cloud Foundry
+// platforms. The values of other platforms are kept as extra information. A nil identity is
+// returned when the platform did not send one.
+func ParseIdentity(originatingIdentity *osb.OriginatingIdentity) (*Identity, error) {
+	if originatingIdentity == nil {
+		return nil, nil
+	}
+
+	parsed, err := osbbroker.ParseIdentity(*originatingIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse originating identity: %v", err)
+	}
+
+	identity := &Identity{Platform: parsed.Platform}
+	switch {
+	case parsed.Kubernetes != nil:
+		identity.Username = parsed.Kubernetes.Username
+		identity.UID = parsed.Kubernetes.UID
+		identity.Groups = parsed.Kubernetes.Groups
+		if len(parsed.Kubernetes.Extra) > 0 {
+			identity.Extra = make(map[string]interface{}, len(parsed.Kubernetes.Extra))
+			for key, value := range parsed.Kubernetes.Extra {
+				identity.Extra[key] = value
+			}
+		}
+	case parsed.CloudFoundry != nil:
+		identity.Username = parsed.CloudFoundry.UserID
+		if len(parsed.CloudFoundry.Extras) > 0 {
+			identity.Extra = parsed.CloudFoundry.Extras
+		}
+	default:
+		identity.Extra = parsed.Unknown
+	}
+
+	return identity, nil
+}
+
+// String returns a short representation of the identity for logging.
+func (i *Identity) String() string {
+	if i == nil {
+		return "<unknown>"
+	}
+	if i.Username == "" {
+		return i.Platform
+	}
+	return fmt.Sprintf("%s:%s", i.Platform, i.Username)
+}
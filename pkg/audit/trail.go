@@ -0,0 +1,132 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Action is an operation requested by a platform user.
+type Action string
+
+// The audited actions.
+const (
+	ActionProvision   Action = "provision"
+	ActionDeprovision Action = "deprovision"
+	ActionUpdate      Action = "update"
+	ActionBind        Action = "bind"
+	ActionUnbind      Action = "unbind"
+)
+
+// Outcome is the result of a requested action at the time it was recorded.
+type Outcome string
+
+// The possible outcomes of an action.
+const (
+	OutcomeSucceeded  Outcome = "succeeded"
+	OutcomeFailed     Outcome = "failed"
+	OutcomeInProgress Outcome = "in progress"
+	// The action is not supported by the broker and had no effect.
+	OutcomeUnsupported Outcome = "unsupported"
+)
+
+// Event is a single entry of the audit trail.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Action     Action    `json:"action"`
+	Outcome    Outcome   `json:"outcome"`
+	InstanceID string    `json:"instance_id"`
+	BindingID  string    `json:"binding_id,omitempty"`
+	ServiceID  string    `json:"service_id,omitempty"`
+	PlanID     string    `json:"plan_id,omitempty"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Identity   *Identity `json:"identity,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Trail is the interface that wraps the Record method for appending events to an audit trail.
+type Trail interface {
+	Record(event Event) error
+}
+
+// StdoutPath is the special path used for writing the audit trail to the standard output.
+const StdoutPath = "-"
+
+// JSONLinesTrail satisfies the Trail interface, writing each event as a JSON document on its own
+// line.
+type JSONLinesTrail struct {
+	mu      sync.Mutex
+	w       io.Writer
+	timeNow func() time.Time
+}
+
+// NewJSONLinesTrail creates a new JSONLinesTrail writing to w.
+func NewJSONLinesTrail(w io.Writer, timeNow func() time.Time) *JSONLinesTrail {
+	return &JSONLinesTrail{
+		w:       w,
+		timeNow: timeNow,
+	}
+}
+
+// NewFileTrail creates a new JSONLinesTrail appending to the file at path. The file is created if
+// it doesn't exist. When path is StdoutPath, the events are written to the standard output.
+func NewFileTrail(path string) (*JSONLinesTrail, error) {
+	if path == StdoutPath {
+		return NewJSONLinesTrail(os.Stdout, time.Now), nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit trail: %v", err)
+	}
+	return NewJSONLinesTrail(f, time.Now), nil
+}
+
+// Record appends the event to the trail. The event time is set when not provided.
+func (t *JSONLinesTrail) Record(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = t.timeNow().UTC()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %v", err)
+	}
+	data = append(data, '\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.w.Write(data); err != nil {
+		return fmt.Errorf("failed to record audit event: %v", err)
+	}
+	return nil
+}
+
+// NewNoopTrail creates a Trail that discards all events. It should be used when auditing is
+// disabled.
+func NewNoopTrail() Trail {
+	return noopTrail{}
+}
+
+type noopTrail struct{}
+
+func (noopTrail) Record(Event) error {
+	return nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	klog "k8s.io/klog/v2"
+)
+
+// unauthenticatedPaths are the paths served without authentication, so that probes and metrics
+// scrapers don't need the broker credentials.
+var unauthenticatedPaths = map[string]struct{}{
+	"/healthz": {},
+	"/metrics": {},
+}
+
+const realm = "minibroker"
+
+// Authenticator authenticates the requests made to the broker API against the credentials loaded
+// from a Source.
+type Authenticator struct {
+	source Source
+
+	mu          sync.RWMutex
+	credentials *Credentials
+}
+
+// NewAuthenticator creates a new Authenticator, loading the initial credentials from the source.
+func NewAuthenticator(source Source) (*Authenticator, error) {
+	credentials, err := source.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authenticator: %v", err)
+	}
+	return &Authenticator{
+		source:      source,
+		credentials: credentials,
+	}, nil
+}
+
+// Run reloads the credentials from the source every interval until the context is done. When the
+// credentials can't be loaded, the previous credentials are kept.
+func (a *Authenticator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Reload(); err != nil {
+				klog.Errorf("auth: %v", err)
+			}
+		}
+	}
+}
+
+// Reload loads the credentials from the source, replacing the current ones if they changed.
+func (a *Authenticator) Reload() error {
+	credentials, err := a.source.Load()
+	if err != nil {
+		return fmt.Errorf("failed to reload credentials: %v", err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !reflect.DeepEqual(a.credentials, credentials) {
+		a.credentials = credentials
+		klog.V(1).Infof("auth: reloaded credentials")
+	}
+	return nil
+}
+
+// Authenticate returns whether the request carries valid credentials.
+func (a *Authenticator) Authenticate(r *http.Request) bool {
+	a.mu.RLock()
+	credentials := a.credentials
+	a.mu.RUnlock()
+
+	if username, password, ok := r.BasicAuth(); ok {
+		if credentials.Username == "" {
+			return false
+		}
+		validUsername := subtle.ConstantTimeCompare([]byte(username), []byte(credentials.Username))
+		validPassword := subtle.ConstantTimeCompare([]byte(password), []byte(credentials.Password))
+		return validUsername&validPassword == 1
+	}
+
+	const bearerPrefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return false
+	}
+	token := []byte(header[len(bearerPrefix):])
+	valid := 0
+	for _, expected := range credentials.Tokens {
+		valid |= subtle.ConstantTimeCompare(token, []byte(expected))
+	}
+	return valid == 1
+}
+
+// Middleware wraps next, responding with 401 Unauthorized to the requests that fail to
+// authenticate. It satisfies the mux.MiddlewareFunc type.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := unauthenticatedPaths[r.URL.Path]; ok || a.Authenticate(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		klog.V(4).Infof("auth: unauthorized request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", realm))
+		unauthorized(w, "The request could not be authenticated")
+	})
+}
+
+// unauthorized responds with 401 Unauthorized and an OSB error body.
+func unauthorized(w http.ResponseWriter, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{
+		"description": description,
+	})
+}
+
+// RequireClientCertificate wraps next, responding with 401 Unauthorized to the requests made
+// without a verified TLS client certificate. The TLS server is expected to verify the certificates
+// presented by clients; only their presence is checked here.
+func RequireClientCertificate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := unauthenticatedPaths[r.URL.Path]
+		if ok || (r.TLS != nil && len(r.TLS.VerifiedChains) > 0) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		klog.V(4).Infof("auth: request %s %s from %s without a client certificate", r.Method, r.URL.Path, r.RemoteAddr)
+		unauthorized(w, "A valid client certificate is required")
+	})
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/kubernetes-sigs/minibroker/pkg/auth"
+)
+
+type fakeSource struct {
+	credentials *auth.Credentials
+	err         error
+}
+
+func (s *fakeSource) Load() (*auth.Credentials, error) {
+	return s.credentials, s.err
+}
+
+var _ = Describe("Authenticator", func() {
+	var (
+		source  *fakeSource
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		source = &fakeSource{credentials: &auth.Credentials{
+			Username: "admin",
+			Password: "s3cr3t",
+			Tokens:   []string{"token-1", "token-2"},
+		}}
+		authenticator, err := auth.NewAuthenticator(source)
+		Expect(err).ToNot(HaveOccurred())
+		handler = authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	serve := func(path string, setAuth func(r *http.Request)) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		if setAuth != nil {
+			setAuth(request)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	basicAuth := func(username, password string) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(username, password) }
+	}
+
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token)) }
+	}
+
+	It("accepts valid basic auth credentials", func() {
+		Expect(serve("/v2/catalog", basicAuth("admin", "s3cr3t")).Code).To(Equal(http.StatusOK))
+	})
+
+	It("accepts any of the valid bearer tokens", func() {
+		Expect(serve("/v2/catalog", bearer("token-1")).Code).To(Equal(http.StatusOK))
+		Expect(serve("/v2/catalog", bearer("token-2")).Code).To(Equal(http.StatusOK))
+	})
+
+	It("rejects invalid credentials", func() {
+		for _, setAuth := range []func(r *http.Request){
+			nil,
+			basicAuth("admin", "wrong"),
+			basicAuth("root", "s3cr3t"),
+			bearer("token-3"),
+			bearer(""),
+		} {
+			recorder := serve("/v2/catalog", setAuth)
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(recorder.Header()["Www-Authenticate"]).To(ConsistOf(`Basic realm="minibroker"`, `Bearer realm="minibroker"`))
+			Expect(recorder.Body.String()).To(ContainSubstring("description"))
+		}
+	})
+
+	It("doesn't require authentication for health checks and metrics", func() {
+		Expect(serve("/healthz", nil).Code).To(Equal(http.StatusOK))
+		Expect(serve("/metrics", nil).Code).To(Equal(http.StatusOK))
+	})
+
+	Describe("Reload", func() {
+		It("replaces the credentials when they change", func() {
+			authenticator, err := auth.NewAuthenticator(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			source.credentials = &auth.Credentials{Tokens: []string{"token-3"}}
+			Expect(authenticator.Reload()).To(Succeed())
+
+			request := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+			bearer("token-3")(request)
+			Expect(authenticator.Authenticate(request)).To(BeTrue())
+			bearer("token-1")(request)
+			Expect(authenticator.Authenticate(request)).To(BeFalse())
+		})
+
+		It("keeps the previous credentials when they fail to load", func() {
+			authenticator, err := auth.NewAuthenticator(source)
+			Expect(err).ToNot(HaveOccurred())
+
+			source.credentials, source.err = nil, fmt.Errorf("boom")
+			Expect(authenticator.Reload()).To(MatchError("failed to reload credentials: boom"))
+
+			request := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+			bearer("token-1")(request)
+			Expect(authenticator.Authenticate(request)).To(BeTrue())
+		})
+	})
+
+	It("fails to be created when the initial credentials can't be loaded", func() {
+		_, err := auth.NewAuthenticator(&fakeSource{err: fmt.Errorf("boom")})
+		Expect(err).To(MatchError("failed to create authenticator: boom"))
+	})
+})
+
+var _ = Describe("RequireClientCertificate", func() {
+	handler := auth.RequireClientCertificate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string, state *tls.ConnectionState) int {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.TLS = state
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	It("accepts requests with a verified client certificate", func() {
+		state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+		Expect(serve("/v2/catalog", state)).To(Equal(http.StatusOK))
+	})
+
+	It("rejects requests without a verified client certificate", func() {
+		Expect(serve("/v2/catalog", &tls.ConnectionState{})).To(Equal(http.StatusUnauthorized))
+		Expect(serve("/v2/catalog", nil)).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("doesn't require client certificates for health checks and metrics", func() {
+		Expect(serve("/healthz", &tls.ConnectionState{})).To(Equal(http.StatusOK))
+		Expect(serve("/metrics", &tls.ConnectionState{})).To(Equal(http.StatusOK))
+	})
+})
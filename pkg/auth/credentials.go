@@ -0,0 +1,90 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The keys of a Kubernetes Secret holding credentials. The username and password keys match the
+// kubernetes.io/basic-auth Secret type. The tokens key holds one bearer token per line, while the
+// token key holds a single bearer token, as read by the Service Catalog for bearer auth.
+const (
+	SecretUsernameKey = "username"
+	SecretPasswordKey = "password"
+	SecretTokensKey   = "tokens"
+	SecretTokenKey    = "token"
+)
+
+// Credentials are the credentials accepted by the broker API.
+type Credentials struct {
+	// The username and password accepted for HTTP basic auth.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// The accepted bearer tokens. More than one token can be set to allow token rotation.
+	Tokens []string `json:"tokens,omitempty"`
+}
+
+// ParseCredentials parses credentials in the YAML or JSON format.
+func ParseCredentials(data []byte) (*Credentials, error) {
+	var credentials Credentials
+	if err := yaml.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %v", err)
+	}
+	if err := credentials.validate(); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %v", err)
+	}
+	return &credentials, nil
+}
+
+// CredentialsFromSecret extracts the credentials from a Kubernetes Secret.
+func CredentialsFromSecret(secret *corev1.Secret) (*Credentials, error) {
+	credentials := Credentials{
+		Username: string(secret.Data[SecretUsernameKey]),
+		Password: string(secret.Data[SecretPasswordKey]),
+	}
+	tokens := strings.Split(string(secret.Data[SecretTokensKey]), "\n")
+	tokens = append(tokens, string(secret.Data[SecretTokenKey]))
+	for _, token := range tokens {
+		if token = strings.TrimSpace(token); token != "" {
+			credentials.Tokens = append(credentials.Tokens, token)
+		}
+	}
+	if err := credentials.validate(); err != nil {
+		return nil, fmt.Errorf("failed to get credentials from secret %s/%s: %v", secret.Namespace, secret.Name, err)
+	}
+	return &credentials, nil
+}
+
+func (c *Credentials) validate() error {
+	if (c.Username == "") != (c.Password == "") {
+		return fmt.Errorf("both the username and the password must be set for basic auth")
+	}
+	for _, token := range c.Tokens {
+		if token == "" {
+			return fmt.Errorf("empty bearer tokens are not allowed")
+		}
+	}
+	if c.Username == "" && len(c.Tokens) == 0 {
+		return fmt.Errorf("either basic auth or bearer tokens must be set")
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/kubernetes-sigs/minibroker/pkg/auth"
+)
+
+var _ = Describe("Credentials", func() {
+	Describe("ParseCredentials", func() {
+		It("parses basic auth and bearer tokens", func() {
+			credentials, err := auth.ParseCredentials([]byte("username: admin\npassword: s3cr3t\ntokens: [foo, bar]\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(credentials).To(Equal(&auth.Credentials{
+				Username: "admin",
+				Password: "s3cr3t",
+				Tokens:   []string{"foo", "bar"},
+			}))
+		})
+
+		It("fails without any credentials", func() {
+			_, err := auth.ParseCredentials([]byte("{}"))
+			Expect(err).To(MatchError("failed to parse credentials: either basic auth or bearer tokens must be set"))
+		})
+
+		It("fails with a username but no password", func() {
+			_, err := auth.ParseCredentials([]byte("username: admin"))
+			Expect(err).To(MatchError("failed to parse credentials: both the username and the password must be set for basic auth"))
+		})
+	})
+
+	Describe("FileSource", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "minibroker-auth")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("loads the credentials from the file", func() {
+			path := filepath.Join(dir, "credentials.yaml")
+			Expect(ioutil.WriteFile(path, []byte(`{"tokens": ["foo"]}`), 0600)).To(Succeed())
+
+			credentials, err := auth.NewFileSource(path).Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(credentials.Tokens).To(Equal([]string{"foo"}))
+		})
+
+		It("fails when the file doesn't exist", func() {
+			_, err := auth.NewFileSource(filepath.Join(dir, "missing.yaml")).Load()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("SecretSource", func() {
+		It("loads the credentials from the secret", func() {
+			client := fake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "minibroker", Name: "broker-auth"},
+				Data: map[string][]byte{
+					auth.SecretUsernameKey: []byte("admin"),
+					auth.SecretPasswordKey: []byte("s3cr3t"),
+					auth.SecretTokensKey:   []byte("foo\n\nbar\n"),
+				},
+			})
+
+			credentials, err := auth.NewSecretSource(client.CoreV1(), "minibroker", "broker-auth").Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(credentials).To(Equal(&auth.Credentials{
+				Username: "admin",
+				Password: "s3cr3t",
+				Tokens:   []string{"foo", "bar"},
+			}))
+		})
+
+		It("loads the token read by the Service Catalog for bearer auth", func() {
+			client := fake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "minibroker", Name: "broker-auth"},
+				Data: map[string][]byte{
+					auth.SecretTokenKey: []byte("foo\n"),
+				},
+			})
+
+			credentials, err := auth.NewSecretSource(client.CoreV1(), "minibroker", "broker-auth").Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(credentials).To(Equal(&auth.Credentials{Tokens: []string{"foo"}}))
+		})
+
+		It("fails when the secret doesn't exist", func() {
+			client := fake.NewSimpleClientset()
+			_, err := auth.NewSecretSource(client.CoreV1(), "minibroker", "broker-auth").Load()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,79 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// Source is the interface that wraps the Load method for fetching the current credentials.
+type Source interface {
+	Load() (*Credentials, error)
+}
+
+// FileSource satisfies the Source interface, loading the credentials from a YAML or JSON file.
+type FileSource struct {
+	path string
+}
+
+// NewFileSource creates a new FileSource.
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+// Load reads and parses the credentials file.
+func (s *FileSource) Load() (*Credentials, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load credentials: %v", err)
+	}
+	credentials, err := ParseCredentials(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load credentials from %q: %v", s.path, err)
+	}
+	return credentials, nil
+}
+
+// SecretSource satisfies the Source interface, loading the credentials from a Kubernetes Secret.
+type SecretSource struct {
+	client    corev1client.SecretsGetter
+	namespace string
+	name      string
+}
+
+// NewSecretSource creates a new SecretSource.
+func NewSecretSource(client corev1client.SecretsGetter, namespace, name string) *SecretSource {
+	return &SecretSource{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// Load fetches the Secret and extracts the credentials from it.
+func (s *SecretSource) Load() (*Credentials, error) {
+	secret, err := s.client.Secrets(s.namespace).Get(context.TODO(), s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load credentials: %v", err)
+	}
+	return CredentialsFromSecret(secret)
+}
@@ -17,12 +17,14 @@ limitations under the License.
 package broker
 
 import (
-	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/ghodss/yaml"
+	"github.com/kubernetes-sigs/minibroker/pkg/audit"
 	"github.com/kubernetes-sigs/minibroker/pkg/minibroker"
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
 	"github.com/pmorie/osb-broker-lib/pkg/broker"
@@ -42,6 +44,8 @@ type ProvisioningSettings struct {
 // ServiceProvisioningSettings represents provisioning settings for a specific service.
 type ServiceProvisioningSettings struct {
 	OverrideParams map[string]interface{} `yaml:"overrideParams"`
+	// Tiers offers every plan of the service once per tier, e.g. 11-7-0-small and 11-7-0-large.
+	Tiers []minibroker.Tier `yaml:"tiers"`
 }
 
 // LoadYaml parses param definitions from raw yaml.
@@ -73,15 +77,28 @@ func (d *ProvisioningSettings) ForService(service string) (*ServiceProvisioningS
 	}
 }
 
+// Tiers returns the tiers of the services, by chart name.
+func (d *ProvisioningSettings) Tiers() map[string][]minibroker.Tier {
+	tiers := make(map[string][]minibroker.Tier)
+	for _, service := range []string{"mariadb", "mongodb", "mysql", "postgresql", "rabbitmq", "redis"} {
+		if settings, _ := d.ForService(service); settings != nil && len(settings.Tiers) > 0 {
+			tiers[service] = settings.Tiers
+		}
+	}
+	return tiers
+}
+
 // MinibrokerClient defines the interface of the client the broker operates on.
 type MinibrokerClient interface {
 	Init(repoURL string) error
 	ListServices() ([]osb.Service, error)
-	Provision(instanceID, serviceID, planID, namespace string, acceptsIncomplete bool, provisionParams *minibroker.ProvisionParams) (string, error)
-	Bind(instanceID, serviceID, bindingID string, acceptsIncomplete bool, bindParams *minibroker.BindParams) (string, error)
+	CatalogGeneration() uint64
+	ServiceChart(serviceID string) (string, bool)
+	Provision(instanceID, serviceID, planID, namespace string, acceptsIncomplete bool, provisionParams *minibroker.ProvisionParams, identity *audit.Identity) (string, error)
+	Bind(instanceID, serviceID, bindingID string, acceptsIncomplete bool, bindParams *minibroker.BindParams, identity *audit.Identity) (string, error)
 	Unbind(instanceID, bindingID string) error
 	GetBinding(instanceID, bindingID string) (*osb.GetBindingResponse, error)
-	Deprovision(instanceID string, acceptsIncomplete bool) (string, error)
+	Deprovision(instanceID string, acceptsIncomplete bool, identity *audit.Identity) (string, error)
 	LastOperationState(instanceID string, operationKey *osb.OperationKey) (*osb.LastOperationResponse, error)
 	LastBindingOperationState(instanceID, bindingID string) (*osb.LastOperationResponse, error)
 }
@@ -91,10 +108,17 @@ type MinibrokerClient interface {
 // Broker the parameters passed in.
 func NewBrokerFromOptions(o Options) (*Broker, error) {
 	klog.V(5).Infof("broker: creating a new broker with options %+v", o)
-	mb := minibroker.NewClient(o.ConfigNamespace, o.ServiceCatalogEnabledOnly, o.ClusterDomain)
-	err := mb.Init(o.HelmRepoURL)
-	if err != nil {
-		return nil, err
+	var catalogConfig *minibroker.CatalogConfig
+	if len(o.CatalogPath) > 0 {
+		data, err := ioutil.ReadFile(o.CatalogPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize the broker: %w", err)
+		}
+
+		catalogConfig, err = minibroker.LoadCatalogConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize the broker: %w", err)
+		}
 	}
 
 	provisioningSettings := &ProvisioningSettings{}
@@ -110,16 +134,60 @@ func NewBrokerFromOptions(o Options) (*Broker, error) {
 		}
 	}
 
-	return NewBroker(mb, o.DefaultNamespace, provisioningSettings), nil
+	var credentialMappings map[string]*minibroker.CredentialMapping
+	if len(o.CredentialMappingsPath) > 0 {
+		data, err := ioutil.ReadFile(o.CredentialMappingsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize the broker: %w", err)
+		}
+
+		credentialMappings, err = minibroker.LoadCredentialMappings(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize the broker: %w", err)
+		}
+	}
+
+	var plugins map[string]*minibroker.PluginProvider
+	if len(o.ProviderPluginDir) > 0 {
+		timeout := o.ProviderPluginTimeout
+		if timeout <= 0 {
+			timeout = minibroker.DefaultPluginTimeout
+		}
+		var err error
+		if plugins, err = minibroker.LoadProviderPlugins(o.ProviderPluginDir, timeout); err != nil {
+			return nil, fmt.Errorf("failed to initialize the broker: %w", err)
+		}
+	}
+
+	mb := minibroker.NewClient(o.ConfigNamespace, o.ServiceCatalogEnabledOnly, o.ClusterDomain, catalogConfig, provisioningSettings.Tiers(), credentialMappings, plugins)
+	if err := mb.Init(o.HelmRepoURL); err != nil {
+		return nil, err
+	}
+
+	auditTrail := audit.NewNoopTrail()
+	if len(o.AuditLogPath) > 0 {
+		var err error
+		if auditTrail, err = audit.NewFileTrail(o.AuditLogPath); err != nil {
+			return nil, fmt.Errorf("failed to initialize the broker: %w", err)
+		}
+	}
+
+	return NewBroker(mb, o.DefaultNamespace, provisioningSettings, auditTrail), nil
 }
 
 // NewBroker creates a Broker instance with the given dependencies.
-func NewBroker(mb MinibrokerClient, defaultNamespace string, provisioningSettings *ProvisioningSettings) *Broker {
+func NewBroker(
+	mb MinibrokerClient,
+	defaultNamespace string,
+	provisioningSettings *ProvisioningSettings,
+	auditTrail audit.Trail,
+) *Broker {
 	return &Broker{
 		client:               mb,
 		async:                true,
 		defaultNamespace:     defaultNamespace,
 		provisioningSettings: provisioningSettings,
+		auditTrail:           auditTrail,
 	}
 }
 
@@ -135,25 +203,29 @@ type Broker struct {
 	defaultNamespace string
 	// Provisioning settings.
 	provisioningSettings *ProvisioningSettings
+	// The audit trail of the operations requested by platform users.
+	auditTrail audit.Trail
+	// The catalog responses rendered for the API versions of the platforms.
+	catalogCache catalogCache
 }
 
 var _ broker.Interface = &Broker{}
 
 func (b *Broker) GetCatalog(c *broker.RequestContext) (*broker.CatalogResponse, error) {
 	klog.V(4).Infoln("broker: getting catalog")
-	services, err := b.client.ListServices()
-	if err != nil {
-		return nil, err
+	// The catalog is only rendered here when the request didn't go through the CatalogMiddleware.
+	var cached *cachedCatalog
+	if c != nil && c.Request != nil {
+		cached, _ = c.Request.Context().Value(catalogContextKey{}).(*cachedCatalog)
 	}
-
-	response := &broker.CatalogResponse{
-		CatalogResponse: osb.CatalogResponse{
-			Services: services,
-		},
+	if cached == nil {
+		var err error
+		if cached, err = b.catalog(c); err != nil {
+			return nil, err
+		}
 	}
-
 	klog.V(4).Infoln("broker: got catalog")
-	return response, nil
+	return cached.response, nil
 }
 
 func (b *Broker) Provision(request *osb.ProvisionRequest, _ *broker.RequestContext) (*broker.ProvisionResponse, error) {
@@ -165,16 +237,35 @@ func (b *Broker) Provision(request *osb.ProvisionRequest, _ *broker.RequestConte
 		namespace = request.Context["namespace"].(string)
 	}
 
+	identity := originatingIdentity(request.OriginatingIdentity)
+	event := audit.Event{
+		Action:     audit.ActionProvision,
+		InstanceID: request.InstanceID,
+		ServiceID:  request.ServiceID,
+		PlanID:     request.PlanID,
+		Namespace:  namespace,
+		Identity:   identity,
+	}
+
 	if namespace == "" {
 		klog.V(4).Infof("broker: failed to provision %q with empty namespace", request.InstanceID)
-		return nil, errors.New("Cannot provision with empty namespace")
+		err := osb.HTTPStatusCodeError{
+			StatusCode:  http.StatusBadRequest,
+			Description: strPtr("Cannot provision with empty namespace"),
+		}
+		b.record(event, false, err)
+		return nil, err
 	}
 
 	klog.V(4).Infof("broker: provisioning request %+v in namespace %q", request, namespace)
 
 	// Check if override parameters are defined for the service to be provisioned.
 	// If defined, those parameters will be used instead of what the user provided.
-	provisioningSettings, found := b.provisioningSettings.ForService(request.ServiceID)
+	chart, ok := b.client.ServiceChart(request.ServiceID)
+	if !ok {
+		chart = request.ServiceID
+	}
+	provisioningSettings, found := b.provisioningSettings.ForService(chart)
 	var params map[string]interface{}
 	if found && provisioningSettings != nil && provisioningSettings.OverrideParams != nil {
 		params = provisioningSettings.OverrideParams
@@ -189,10 +280,12 @@ func (b *Broker) Provision(request *osb.ProvisionRequest, _ *broker.RequestConte
 		namespace,
 		request.AcceptsIncomplete,
 		minibroker.NewProvisionParams(params),
+		identity,
 	)
+	b.record(event, request.AcceptsIncomplete, err)
 	if err != nil {
 		klog.V(4).Infof("broker: failed to provision request %q: %v", request.InstanceID, err)
-		return nil, err
+		return nil, minibroker.ToHTTPStatusCodeError(err)
 	}
 
 	response := broker.ProvisionResponse{}
@@ -212,10 +305,18 @@ func (b *Broker) Deprovision(request *osb.DeprovisionRequest, _ *broker.RequestC
 	b.Lock()
 	defer b.Unlock()
 
-	operationName, err := b.client.Deprovision(request.InstanceID, request.AcceptsIncomplete)
+	identity := originatingIdentity(request.OriginatingIdentity)
+	operationName, err := b.client.Deprovision(request.InstanceID, request.AcceptsIncomplete, identity)
+	b.record(audit.Event{
+		Action:     audit.ActionDeprovision,
+		InstanceID: request.InstanceID,
+		ServiceID:  request.ServiceID,
+		PlanID:     request.PlanID,
+		Identity:   identity,
+	}, request.AcceptsIncomplete, err)
 	if err != nil {
 		klog.V(4).Infof("broker: failed to deprovision %q: %v", request.InstanceID, err)
-		return nil, err
+		return nil, minibroker.ToHTTPStatusCodeError(err)
 	}
 
 	response := broker.DeprovisionResponse{}
@@ -236,11 +337,23 @@ func (b *Broker) LastOperation(request *osb.LastOperationRequest, _ *broker.Requ
 	b.Lock()
 	defer b.Unlock()
 
+	event := audit.Event{
+		InstanceID: request.InstanceID,
+		ServiceID:  stringValue(request.ServiceID),
+		PlanID:     stringValue(request.PlanID),
+		Identity:   originatingIdentity(request.OriginatingIdentity),
+	}
 	response, err := b.client.LastOperationState(request.InstanceID, request.OperationKey)
 	if err != nil {
+		// Nothing is left of a deprovisioned instance: its absence is the outcome the
+		// platform polls for.
+		if httpErr, ok := osb.IsHTTPError(err); ok && httpErr.StatusCode == http.StatusGone {
+			b.recordCompletion(event, request.OperationKey, &osb.LastOperationResponse{State: osb.StateSucceeded})
+		}
 		klog.V(4).Infof("broker: failed to get last operation for instance %q: %v", request.InstanceID, err)
-		return nil, err
+		return nil, minibroker.ToHTTPStatusCodeError(err)
 	}
+	b.recordCompletion(event, request.OperationKey, response)
 
 	wrappedResponse := broker.LastOperationResponse{LastOperationResponse: *response}
 
@@ -248,26 +361,41 @@ func (b *Broker) LastOperation(request *osb.LastOperationRequest, _ *broker.Requ
 	return &wrappedResponse, nil
 }
 
-func (b *Broker) Bind(request *osb.BindRequest, _ *broker.RequestContext) (*broker.BindResponse, error) {
+func (b *Broker) Bind(request *osb.BindRequest, c *broker.RequestContext) (*broker.BindResponse, error) {
 	klog.V(4).Infof("broker: binding request %+v", request)
 
 	b.Lock()
 	defer b.Unlock()
 
+	// Platforms that do not support asynchronous bindings are always served synchronously.
+	acceptsIncomplete := request.AcceptsIncomplete && requestAPIVersion(c).Supports(FeatureAsyncBindings)
+
+	identity := originatingIdentity(request.OriginatingIdentity)
+	event := audit.Event{
+		Action:     audit.ActionBind,
+		InstanceID: request.InstanceID,
+		BindingID:  request.BindingID,
+		ServiceID:  request.ServiceID,
+		PlanID:     request.PlanID,
+		Identity:   identity,
+	}
+
 	operationName, err := b.client.Bind(
 		request.InstanceID,
 		request.ServiceID,
 		request.BindingID,
-		request.AcceptsIncomplete,
+		acceptsIncomplete,
 		minibroker.NewBindParams(request.Parameters),
+		identity,
 	)
 	if err != nil {
+		b.record(event, false, err)
 		klog.V(4).Infof("broker: failed to bind %q: %v", request.InstanceID, err)
-		return nil, err
+		return nil, minibroker.ToHTTPStatusCodeError(err)
 	}
 
 	operationKey := osb.OperationKey(operationName)
-	if request.AcceptsIncomplete {
+	if acceptsIncomplete {
 		// If we accept incomplete, we can just return directly
 		response := broker.BindResponse{
 			BindResponse: osb.BindResponse{
@@ -275,23 +403,37 @@ func (b *Broker) Bind(request *osb.BindRequest, _ *broker.RequestContext) (*brok
 				OperationKey: &operationKey,
 			},
 		}
+		b.record(event, true, nil)
 		return &response, nil
 	}
 
 	// Get the response back out of the configmaps
 	operationState, err := b.client.LastBindingOperationState(request.InstanceID, request.BindingID)
 	if err != nil {
+		b.record(event, false, err)
 		klog.V(4).Infof("broker: failed to bind %q: %v", request.InstanceID, err)
-		return nil, err
+		return nil, minibroker.ToHTTPStatusCodeError(err)
 	}
 	if operationState.State != osb.StateSucceeded {
 		klog.V(4).Infof("broker: failed to bind instance %q: state is %q", request.InstanceID, operationState.State)
-		return nil, errors.New("Failed to bind instance")
+		description := "Failed to bind instance"
+		if operationState.Description != nil {
+			description = *operationState.Description
+		}
+		err := osb.HTTPStatusCodeError{
+			StatusCode:  http.StatusInternalServerError,
+			Description: &description,
+		}
+		b.record(event, false, err)
+		return nil, err
 	}
+	// The binding is complete at this point; the outcome is recorded regardless
+	// of the lookup below.
+	b.record(event, false, nil)
 	binding, err := b.client.GetBinding(request.InstanceID, request.BindingID)
 	if err != nil {
 		klog.V(4).Infof("broker: failed to bind %q: %v", request.InstanceID, err)
-		return nil, err
+		return nil, minibroker.ToHTTPStatusCodeError(err)
 	}
 
 	bindResponse := broker.BindResponse{
@@ -308,13 +450,17 @@ func (b *Broker) Bind(request *osb.BindRequest, _ *broker.RequestContext) (*brok
 	return &bindResponse, nil
 }
 
-func (b *Broker) GetBinding(request *osb.GetBindingRequest, _ *broker.RequestContext) (*broker.GetBindingResponse, error) {
+func (b *Broker) GetBinding(request *osb.GetBindingRequest, c *broker.RequestContext) (*broker.GetBindingResponse, error) {
 	klog.V(4).Infof("broker: getting binding request %+v", request)
 
+	if err := requireFeature(c, FeatureGetBinding); err != nil {
+		return nil, err
+	}
+
 	binding, err := b.client.GetBinding(request.InstanceID, request.BindingID)
 	if err != nil {
 		klog.V(4).Infof("broker: failed to get binding %q for instance %q: %v", request.BindingID, request.InstanceID, err)
-		return nil, err
+		return nil, minibroker.ToHTTPStatusCodeError(err)
 	}
 	response := broker.GetBindingResponse{
 		GetBindingResponse: *binding,
@@ -325,14 +471,26 @@ func (b *Broker) GetBinding(request *osb.GetBindingRequest, _ *broker.RequestCon
 	return &response, nil
 }
 
-func (b *Broker) BindingLastOperation(request *osb.BindingLastOperationRequest, _ *broker.RequestContext) (*broker.LastOperationResponse, error) {
+func (b *Broker) BindingLastOperation(request *osb.BindingLastOperationRequest, c *broker.RequestContext) (*broker.LastOperationResponse, error) {
 	klog.V(4).Infof("broker: getting binding last operation request %+v", request)
 
+	if err := requireFeature(c, FeatureBindingLastOperation); err != nil {
+		return nil, err
+	}
+
 	state, err := b.client.LastBindingOperationState(request.InstanceID, request.BindingID)
 	if err != nil {
 		klog.V(4).Infof("broker: failed to get binding %q last operation for instance %q: %v", request.BindingID, request.InstanceID, err)
-		return nil, err
+		return nil, minibroker.ToHTTPStatusCodeError(err)
 	}
+	b.recordCompletion(audit.Event{
+		Action:     audit.ActionBind,
+		InstanceID: request.InstanceID,
+		BindingID:  request.BindingID,
+		ServiceID:  stringValue(request.ServiceID),
+		PlanID:     stringValue(request.PlanID),
+		Identity:   originatingIdentity(request.OriginatingIdentity),
+	}, nil, state)
 
 	response := broker.LastOperationResponse{LastOperationResponse: *state}
 
@@ -344,9 +502,18 @@ func (b *Broker) BindingLastOperation(request *osb.BindingLastOperationRequest,
 func (b *Broker) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (*broker.UnbindResponse, error) {
 	klog.V(4).Infof("broker: unbinding request %+v", request)
 
-	if err := b.client.Unbind(request.InstanceID, request.BindingID); err != nil {
+	err := b.client.Unbind(request.InstanceID, request.BindingID)
+	b.record(audit.Event{
+		Action:     audit.ActionUnbind,
+		InstanceID: request.InstanceID,
+		BindingID:  request.BindingID,
+		ServiceID:  request.ServiceID,
+		PlanID:     request.PlanID,
+		Identity:   originatingIdentity(request.OriginatingIdentity),
+	}, false, err)
+	if err != nil {
 		klog.V(4).Infof("broker: failed to unbind instance %q: %v", request.InstanceID, err)
-		return nil, err
+		return nil, minibroker.ToHTTPStatusCodeError(err)
 	}
 
 	// The unbind is always synchronous
@@ -359,6 +526,14 @@ func (b *Broker) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (*
 
 func (b *Broker) Update(request *osb.UpdateInstanceRequest, _ *broker.RequestContext) (*broker.UpdateInstanceResponse, error) {
 	// Not supported, do nothing
+	b.write(audit.Event{
+		Action:     audit.ActionUpdate,
+		Outcome:    audit.OutcomeUnsupported,
+		InstanceID: request.InstanceID,
+		ServiceID:  request.ServiceID,
+		Identity:   originatingIdentity(request.OriginatingIdentity),
+		Error:      "updating service instances is not supported",
+	})
 
 	response := broker.UpdateInstanceResponse{}
 	if request.AcceptsIncomplete {
@@ -368,6 +543,87 @@ func (b *Broker) Update(request *osb.UpdateInstanceRequest, _ *broker.RequestCon
 	return &response, nil
 }
 
+// ValidateBrokerAPIVersion rejects the OSB API versions Minibroker does not support.
 func (b *Broker) ValidateBrokerAPIVersion(version string) error {
+	if _, err := NegotiateAPIVersion(version); err != nil {
+		klog.V(4).Infof("broker: rejecting API version %q: %v", version, err)
+		return err
+	}
 	return nil
 }
+
+// originatingIdentity decodes the identity of the platform user that originated a request. Invalid
+// identities are logged and ignored.
+func originatingIdentity(originatingIdentity *osb.OriginatingIdentity) *audit.Identity {
+	identity, err := audit.ParseIdentity(originatingIdentity)
+	if err != nil {
+		klog.V(3).Infof("broker: ignoring originating identity: %v", err)
+		return nil
+	}
+	return identity
+}
+
+// record appends the event to the audit trail, deriving its outcome from err and whether the
+// operation continues asynchronously.
+func (b *Broker) record(event audit.Event, async bool, err error) {
+	switch {
+	case err != nil:
+		event.Outcome = audit.OutcomeFailed
+		event.Error = minibroker.DescribeError(err)
+	case async:
+		event.Outcome = audit.OutcomeInProgress
+	default:
+		event.Outcome = audit.OutcomeSucceeded
+	}
+	b.write(event)
+}
+
+// recordCompletion records the terminal outcome of an asynchronous operation reported by its last
+// operation state. Operations still in progress were already recorded when requested. The action
+// of instance operations is derived from the operation key when the event doesn't set it.
+func (b *Broker) recordCompletion(event audit.Event, operationKey *osb.OperationKey, state *osb.LastOperationResponse) {
+	if event.Action == "" {
+		if operationKey == nil {
+			return
+		}
+		switch key := string(*operationKey); {
+		case strings.HasPrefix(key, minibroker.OperationPrefixProvision):
+			event.Action = audit.ActionProvision
+		case strings.HasPrefix(key, minibroker.OperationPrefixDeprovision):
+			event.Action = audit.ActionDeprovision
+		default:
+			return
+		}
+	}
+	switch state.State {
+	case osb.StateSucceeded:
+		event.Outcome = audit.OutcomeSucceeded
+	case osb.StateFailed:
+		event.Outcome = audit.OutcomeFailed
+		if state.Description != nil {
+			event.Error = minibroker.SanitizeDescription(*state.Description)
+		}
+	default:
+		return
+	}
+	b.write(event)
+}
+
+// write appends the event to the audit trail.
+func (b *Broker) write(event audit.Event) {
+	klog.V(4).Infof("broker: %s of instance %q requested by %s: %s", event.Action, event.InstanceID, event.Identity, event.Outcome)
+	if err := b.auditTrail.Record(event); err != nil {
+		klog.Errorf("broker: %v", err)
+	}
+}
+
+func strPtr(value string) *string {
+	return &value
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
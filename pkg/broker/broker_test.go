@@ -17,6 +17,13 @@ limitations under the License.
 package broker_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
 	"github.com/ghodss/yaml"
 	"github.com/golang/mock/gomock"
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
@@ -25,6 +32,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/kubernetes-sigs/minibroker/pkg/audit"
 	"github.com/kubernetes-sigs/minibroker/pkg/broker"
 	"github.com/kubernetes-sigs/minibroker/pkg/broker/mocks"
 	"github.com/kubernetes-sigs/minibroker/pkg/minibroker"
@@ -64,15 +72,20 @@ var _ = Describe("Broker", func() {
 
 		provisioningSettings = &broker.ProvisioningSettings{}
 		namespace            = "namespace"
+
+		auditLog   *bytes.Buffer
+		auditTrail audit.Trail
 	)
 
 	BeforeEach(func() {
 		ctrl = gomock.NewController(GinkgoT())
 		mbclient = mocks.NewMockMinibrokerClient(ctrl)
+		auditLog = &bytes.Buffer{}
+		auditTrail = audit.NewJSONLinesTrail(auditLog, time.Now)
 	})
 
 	JustBeforeEach(func() {
-		b = broker.NewBroker(mbclient, namespace, provisioningSettings)
+		b = broker.NewBroker(mbclient, namespace, provisioningSettings, auditTrail)
 	})
 
 	AfterEach(func() {
@@ -91,15 +104,73 @@ var _ = Describe("Broker", func() {
 			requestContext = &osbbroker.RequestContext{}
 		)
 
+		BeforeEach(func() {
+			mbclient.EXPECT().
+				ServiceChart(gomock.Any()).
+				DoAndReturn(func(serviceID string) (string, bool) { return serviceID, true }).
+				AnyTimes()
+		})
+
 		Context("without default chart values", func() {
 			It("passes on unaltered provision params", func() {
 				mbclient.EXPECT().
-					Provision(gomock.Any(), gomock.Eq("redis"), gomock.Any(), gomock.Eq(namespace), gomock.Any(), gomock.Eq(provisionParams))
+					Provision(gomock.Any(), gomock.Eq("redis"), gomock.Any(), gomock.Eq(namespace), gomock.Any(), gomock.Eq(provisionParams), gomock.Any())
 
 				b.Provision(provisionRequest, requestContext)
 			})
 		})
 
+		Context("with an originating identity", func() {
+			It("passes on the identity and records it in the audit trail", func() {
+				request := *provisionRequest
+				request.InstanceID = "foo"
+				request.OriginatingIdentity = &osb.OriginatingIdentity{
+					Platform: "kubernetes",
+					Value:    `{"username": "jdoe", "uid": "1234", "groups": ["admins"]}`,
+				}
+				identity := &audit.Identity{
+					Platform: "kubernetes",
+					Username: "jdoe",
+					UID:      "1234",
+					Groups:   []string{"admins"},
+				}
+
+				mbclient.EXPECT().
+					Provision("foo", "redis", gomock.Any(), namespace, false, gomock.Any(), gomock.Eq(identity)).
+					Return("", nil)
+
+				_, err := b.Provision(&request, requestContext)
+				Expect(err).ToNot(HaveOccurred())
+
+				var event audit.Event
+				Expect(json.Unmarshal(auditLog.Bytes(), &event)).To(Succeed())
+				Expect(event.Action).To(Equal(audit.ActionProvision))
+				Expect(event.Outcome).To(Equal(audit.OutcomeSucceeded))
+				Expect(event.InstanceID).To(Equal("foo"))
+				Expect(event.Namespace).To(Equal(namespace))
+				Expect(event.Identity).To(Equal(identity))
+			})
+		})
+
+		Context("when provisioning fails", func() {
+			It("returns the classified error with a sanitized description", func() {
+				mbclient.EXPECT().
+					Provision(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return("", &minibroker.OperationError{
+						Kind:       minibroker.ErrorKindHelm,
+						StatusCode: http.StatusBadRequest,
+						Err:        fmt.Errorf("failed to install chart: redis://:s3cr3t@host unreachable"),
+					})
+
+				_, err := b.Provision(provisionRequest, requestContext)
+				httpErr, ok := osb.IsHTTPError(err)
+				Expect(ok).To(BeTrue())
+				Expect(httpErr.StatusCode).To(Equal(http.StatusBadRequest))
+				Expect(*httpErr.ErrorMessage).To(Equal(string(minibroker.ErrorKindHelm)))
+				Expect(*httpErr.Description).To(Equal("failed to install chart: redis://<redacted>@host unreachable"))
+			})
+		})
+
 		Context("with default chart values", func() {
 			BeforeEach(func() {
 				provisioningSettings = &broker.ProvisioningSettings{}
@@ -117,11 +188,365 @@ var _ = Describe("Broker", func() {
 					params := minibroker.NewProvisionParams(provisioningSettings.OverrideParams)
 
 					mbclient.EXPECT().
-						Provision(gomock.Any(), gomock.Eq(service), gomock.Any(), gomock.Eq(namespace), gomock.Any(), gomock.Eq(params))
+						Provision(gomock.Any(), gomock.Eq(service), gomock.Any(), gomock.Eq(namespace), gomock.Any(), gomock.Eq(params), gomock.Any())
 
 					b.Provision(provisionRequest, requestContext)
 				}
 			})
+
+			It("looks up the default chart values by the chart backing the service", func() {
+				request := *provisionRequest
+				request.ServiceID = "9f6bdc52-5a4b-5b0a-9b8c-7d4d0f1c9a3e"
+				params := minibroker.NewProvisionParams(map[string]interface{}{"redis": "value"})
+
+				chartClient := mocks.NewMockMinibrokerClient(ctrl)
+				chartClient.EXPECT().
+					ServiceChart(request.ServiceID).
+					Return("redis", true)
+				chartClient.EXPECT().
+					Provision(gomock.Any(), gomock.Eq(request.ServiceID), gomock.Any(), gomock.Eq(namespace), gomock.Any(), gomock.Eq(params), gomock.Any())
+
+				b = broker.NewBroker(chartClient, namespace, provisioningSettings, auditTrail)
+				b.Provision(&request, requestContext)
+			})
+		})
+	})
+
+	Describe("LastOperation", func() {
+		auditEvents := func() []audit.Event {
+			var events []audit.Event
+			decoder := json.NewDecoder(auditLog)
+			for decoder.More() {
+				var event audit.Event
+				Expect(decoder.Decode(&event)).To(Succeed())
+				events = append(events, event)
+			}
+			return events
+		}
+
+		lastOperation := func(operationKey string) *osb.LastOperationRequest {
+			key := osb.OperationKey(operationKey)
+			return &osb.LastOperationRequest{InstanceID: "foo", OperationKey: &key}
+		}
+
+		It("records the outcome of a completed provision", func() {
+			description := "install failed: password=s3cr3t"
+			mbclient.EXPECT().
+				LastOperationState("foo", gomock.Any()).
+				Return(&osb.LastOperationResponse{State: osb.StateFailed, Description: &description}, nil)
+
+			_, err := b.LastOperation(lastOperation(minibroker.OperationPrefixProvision+"1"), nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			events := auditEvents()
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Action).To(Equal(audit.ActionProvision))
+			Expect(events[0].Outcome).To(Equal(audit.OutcomeFailed))
+			Expect(events[0].Error).To(Equal("install failed: password=<redacted>"))
+		})
+
+		It("records a deprovision as succeeded once the instance is gone", func() {
+			mbclient.EXPECT().
+				LastOperationState("foo", gomock.Any()).
+				Return(nil, osb.HTTPStatusCodeError{StatusCode: http.StatusGone})
+
+			_, err := b.LastOperation(lastOperation(minibroker.OperationPrefixDeprovision+"1"), nil)
+			Expect(err).To(HaveOccurred())
+
+			events := auditEvents()
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Action).To(Equal(audit.ActionDeprovision))
+			Expect(events[0].Outcome).To(Equal(audit.OutcomeSucceeded))
+		})
+
+		It("doesn't record operations in progress", func() {
+			mbclient.EXPECT().
+				LastOperationState("foo", gomock.Any()).
+				Return(&osb.LastOperationResponse{State: osb.StateInProgress}, nil)
+
+			_, err := b.LastOperation(lastOperation(minibroker.OperationPrefixProvision+"1"), nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(auditEvents()).To(BeEmpty())
+		})
+	})
+
+	Describe("Update", func() {
+		It("records the update as unsupported", func() {
+			_, err := b.Update(&osb.UpdateInstanceRequest{InstanceID: "foo"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			var event audit.Event
+			Expect(json.Unmarshal(auditLog.Bytes(), &event)).To(Succeed())
+			Expect(event.Action).To(Equal(audit.ActionUpdate))
+			Expect(event.Outcome).To(Equal(audit.OutcomeUnsupported))
+		})
+	})
+})
+
+var _ = Describe("API version", func() {
+	Describe("ValidateBrokerAPIVersion", func() {
+		b := broker.NewBroker(nil, "", &broker.ProvisioningSettings{}, audit.NewNoopTrail())
+
+		It("accepts the supported versions", func() {
+			for _, version := range []string{"2.11", "2.13", "2.14", "2.15", "2.17"} {
+				Expect(b.ValidateBrokerAPIVersion(version)).To(Succeed())
+			}
+		})
+
+		It("rejects unsupported versions with 412", func() {
+			for _, version := range []string{"", "2", "2.x", "2.10", "1.14", "3.0"} {
+				err := b.ValidateBrokerAPIVersion(version)
+				httpErr, ok := osb.IsHTTPError(err)
+				Expect(ok).To(BeTrue())
+				Expect(httpErr.StatusCode).To(Equal(http.StatusPreconditionFailed))
+			}
+		})
+	})
+
+	Describe("NegotiateAPIVersion", func() {
+		It("caps newer minor versions to the maximum supported", func() {
+			version, err := broker.NegotiateAPIVersion("2.17")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version).To(Equal(broker.MaxAPIVersion))
+		})
+
+		It("gates features on the negotiated version", func() {
+			version, err := broker.NegotiateAPIVersion("2.13")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version.Supports(broker.FeatureAsyncBindings)).To(BeFalse())
+			Expect(version.Supports(broker.FeatureGetBinding)).To(BeFalse())
+
+			version, err = broker.NegotiateAPIVersion("2.14")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version.Supports(broker.FeatureAsyncBindings)).To(BeTrue())
+			Expect(version.Supports(broker.FeatureGetBinding)).To(BeTrue())
+			Expect(version.Supports(broker.FeatureMaintenanceInfo)).To(BeFalse())
+		})
+	})
+
+	Describe("GetCatalog", func() {
+		var (
+			ctrl     *gomock.Controller
+			mbclient *mocks.MockMinibrokerClient
+			b        *broker.Broker
+		)
+
+		var generation uint64
+
+		BeforeEach(func() {
+			ctrl = gomock.NewController(GinkgoT())
+			mbclient = mocks.NewMockMinibrokerClient(ctrl)
+			b = broker.NewBroker(mbclient, "namespace", &broker.ProvisioningSettings{}, audit.NewNoopTrail())
+			generation = 1
+			mbclient.EXPECT().CatalogGeneration().DoAndReturn(func() uint64 { return generation }).AnyTimes()
+		})
+
+		AfterEach(func() {
+			ctrl.Finish()
+		})
+
+		requestContext := func(version string) *osbbroker.RequestContext {
+			request := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+			request.Header.Set(osb.APIVersionHeader, version)
+			return &osbbroker.RequestContext{Request: request}
+		}
+
+		schemas := &osb.ParameterSchemas{
+			ServiceInstances: &osb.ServiceInstanceSchema{
+				Create: &osb.InputParameters{Parameters: map[string]interface{}{"type": "object"}},
+			},
+		}
+		services := []osb.Service{{ID: "redis", Plans: []osb.Plan{{ID: "redis-5-0-7", ParameterSchemas: schemas}}}}
+
+		It("publishes the plan schemas to platforms supporting them", func() {
+			mbclient.EXPECT().ListServices().Return(services, nil)
+
+			response, err := b.GetCatalog(requestContext("2.13"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Services[0].Plans[0].ParameterSchemas).To(Equal(schemas))
+		})
+
+		It("leaves the plan schemas out for older platforms", func() {
+			mbclient.EXPECT().ListServices().Return(services, nil)
+
+			response, err := b.GetCatalog(requestContext("2.12"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Services[0].Plans[0].ParameterSchemas).To(BeNil())
+			Expect(services[0].Plans[0].ParameterSchemas).To(Equal(schemas))
+		})
+
+		It("renders the catalog once per API version", func() {
+			mbclient.EXPECT().ListServices().Return(services, nil).Times(2)
+
+			first, err := b.GetCatalog(requestContext("2.13"))
+			Expect(err).ToNot(HaveOccurred())
+			second, err := b.GetCatalog(requestContext("2.13"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(second).To(BeIdenticalTo(first))
+			older, err := b.GetCatalog(requestContext("2.12"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(older).ToNot(BeIdenticalTo(first))
+		})
+
+		It("renders the catalog again when its generation changes", func() {
+			rebuilt := []osb.Service{{ID: "redis", Plans: []osb.Plan{{ID: "redis-6-0-0"}}}}
+			gomock.InOrder(
+				mbclient.EXPECT().ListServices().Return(services, nil),
+				mbclient.EXPECT().ListServices().Return(rebuilt, nil),
+			)
+
+			_, err := b.GetCatalog(requestContext("2.13"))
+			Expect(err).ToNot(HaveOccurred())
+			generation++
+			response, err := b.GetCatalog(requestContext("2.13"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Services[0].Plans[0].ID).To(Equal("redis-6-0-0"))
+		})
+
+		Context("CatalogMiddleware", func() {
+			var handlerCalls int
+
+			serve := func(request *http.Request) *httptest.ResponseRecorder {
+				next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					handlerCalls++
+					w.WriteHeader(http.StatusOK)
+				})
+				recorder := httptest.NewRecorder()
+				b.CatalogMiddleware(next).ServeHTTP(recorder, request)
+				return recorder
+			}
+
+			BeforeEach(func() {
+				handlerCalls = 0
+			})
+
+			It("tags the catalog with an ETag", func() {
+				mbclient.EXPECT().ListServices().Return(services, nil)
+
+				recorder := serve(requestContext("2.13").Request)
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(recorder.Header().Get("ETag")).To(MatchRegexp(`^"[0-9a-f]{32}"$`))
+				Expect(recorder.Header().Get("Vary")).To(Equal(osb.APIVersionHeader))
+				Expect(handlerCalls).To(Equal(1))
+			})
+
+			It("tags the catalogs of different API versions differently", func() {
+				mbclient.EXPECT().ListServices().Return(services, nil).Times(2)
+
+				etag := serve(requestContext("2.13").Request).Header().Get("ETag")
+				Expect(serve(requestContext("2.12").Request).Header().Get("ETag")).ToNot(Equal(etag))
+			})
+
+			It("responds with 304 Not Modified to matching conditional requests", func() {
+				mbclient.EXPECT().ListServices().Return(services, nil)
+
+				etag := serve(requestContext("2.13").Request).Header().Get("ETag")
+				for _, ifNoneMatch := range []string{etag, `"other", W/` + etag} {
+					request := requestContext("2.13").Request
+					request.Header.Set("If-None-Match", ifNoneMatch)
+					recorder := serve(request)
+					Expect(recorder.Code).To(Equal(http.StatusNotModified))
+					Expect(recorder.Header().Get("ETag")).To(Equal(etag))
+					Expect(recorder.Body.Len()).To(BeZero())
+				}
+				Expect(handlerCalls).To(Equal(1))
+			})
+
+			It("serves the catalog to conditional requests for another catalog", func() {
+				mbclient.EXPECT().ListServices().Return(services, nil)
+
+				request := requestContext("2.13").Request
+				request.Header.Set("If-None-Match", `"stale"`)
+				Expect(serve(request).Code).To(Equal(http.StatusOK))
+				Expect(handlerCalls).To(Equal(1))
+			})
+
+			It("hands the rendered catalog to GetCatalog", func() {
+				mbclient.EXPECT().ListServices().Return(services, nil)
+
+				var response *osbbroker.CatalogResponse
+				next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					// A catalog built after the middleware rendered it is not served to this request.
+					generation++
+					var err error
+					response, err = b.GetCatalog(&osbbroker.RequestContext{Writer: w, Request: r})
+					Expect(err).ToNot(HaveOccurred())
+				})
+				b.CatalogMiddleware(next).ServeHTTP(httptest.NewRecorder(), requestContext("2.13").Request)
+				Expect(response.Services).To(Equal(services))
+			})
+
+			It("leaves other requests and invalid API versions to the handlers", func() {
+				serve(httptest.NewRequest(http.MethodGet, "/v2/service_instances/1", nil))
+				recorder := serve(requestContext("1.0").Request)
+				Expect(recorder.Header().Get("ETag")).To(BeEmpty())
+				Expect(handlerCalls).To(Equal(2))
+			})
+		})
+	})
+
+	Describe("Bind", func() {
+		var (
+			ctrl     *gomock.Controller
+			mbclient *mocks.MockMinibrokerClient
+			b        *broker.Broker
+		)
+
+		BeforeEach(func() {
+			ctrl = gomock.NewController(GinkgoT())
+			mbclient = mocks.NewMockMinibrokerClient(ctrl)
+			b = broker.NewBroker(mbclient, "namespace", &broker.ProvisioningSettings{}, audit.NewNoopTrail())
+		})
+
+		AfterEach(func() {
+			ctrl.Finish()
+		})
+
+		requestContext := func(version string) *osbbroker.RequestContext {
+			request := httptest.NewRequest(http.MethodPut, "/v2/service_instances/foo/service_bindings/bar", nil)
+			request.Header.Set(osb.APIVersionHeader, version)
+			return &osbbroker.RequestContext{Request: request}
+		}
+
+		bindRequest := &osb.BindRequest{
+			InstanceID:        "foo",
+			BindingID:         "bar",
+			ServiceID:         "redis",
+			AcceptsIncomplete: true,
+		}
+
+		It("binds asynchronously when the platform supports it", func() {
+			mbclient.EXPECT().
+				Bind("foo", "redis", "bar", true, gomock.Any(), gomock.Any()).
+				Return("bind-1", nil)
+
+			response, err := b.Bind(bindRequest, requestContext("2.14"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Async).To(BeTrue())
+		})
+
+		It("binds synchronously for older platforms", func() {
+			mbclient.EXPECT().
+				Bind("foo", "redis", "bar", false, gomock.Any(), gomock.Any()).
+				Return("", nil)
+			mbclient.EXPECT().
+				LastBindingOperationState("foo", "bar").
+				Return(&osb.LastOperationResponse{State: osb.StateSucceeded}, nil)
+			mbclient.EXPECT().
+				GetBinding("foo", "bar").
+				Return(&osb.GetBindingResponse{Credentials: map[string]interface{}{"foo": "bar"}}, nil)
+
+			response, err := b.Bind(bindRequest, requestContext("2.13"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Async).To(BeFalse())
+			Expect(response.Credentials).To(HaveKeyWithValue("foo", "bar"))
+		})
+
+		It("refuses to fetch bindings for older platforms", func() {
+			_, err := b.GetBinding(&osb.GetBindingRequest{InstanceID: "foo", BindingID: "bar"}, requestContext("2.13"))
+			httpErr, ok := osb.IsHTTPError(err)
+			Expect(ok).To(BeTrue())
+			Expect(httpErr.StatusCode).To(Equal(http.StatusBadRequest))
 		})
 	})
 })
@@ -148,6 +573,38 @@ var _ = Describe("OverrideChartParams", func() {
 			Expect(p.OverrideParams["rabbitmqdata"]).To(Equal("thevalue"))
 		})
 
+		It("Loads the tiers by chart", func() {
+			settings := &broker.ProvisioningSettings{}
+			err := settings.LoadYaml([]byte(`
+postgresql:
+  tiers:
+  - name: small
+    description: 1Gi of storage
+    values:
+      persistence:
+        size: 1Gi
+  - name: large
+    values:
+      persistence:
+        size: 50Gi
+`))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings.Tiers()).To(Equal(map[string][]minibroker.Tier{
+				"postgresql": {
+					{
+						Name:        "small",
+						Description: "1Gi of storage",
+						Values:      map[string]interface{}{"persistence": map[string]interface{}{"size": "1Gi"}},
+					},
+					{
+						Name:   "large",
+						Values: map[string]interface{}{"persistence": map[string]interface{}{"size": "50Gi"}},
+					},
+				},
+			}))
+		})
+
 		It("returns an error on unknown fields", func() {
 			yamlStr, _ := yaml.Marshal(map[string]interface{}{
 				"unknownservice": map[string]interface{}{
@@ -0,0 +1,182 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	klog "k8s.io/klog/v2"
+
+	"github.com/kubernetes-sigs/minibroker/pkg/minibroker"
+)
+
+// catalogPath is the path of the OSB catalog endpoint.
+const catalogPath = "/v2/catalog"
+
+// catalogContextKey is the request context key of the catalog response rendered by the
+// CatalogMiddleware, which GetCatalog serves.
+type catalogContextKey struct{}
+
+// catalogVariant identifies the features a catalog response is rendered with, as they depend on
+// the API version of the platform.
+type catalogVariant struct {
+	bindingsRetrievable bool
+	parameterSchemas    bool
+}
+
+// cachedCatalog is a catalog response rendered for a variant and its entity tag. The response is
+// shared by the requests and must not be modified.
+type cachedCatalog struct {
+	response *broker.CatalogResponse
+	etag     string
+}
+
+// catalogCache holds the catalog responses rendered from the services listed by the Minibroker
+// client. The responses are rendered once per catalog generation of the client, and rendered again
+// when the generation changes.
+type catalogCache struct {
+	sync.Mutex
+	generation uint64
+	responses  map[catalogVariant]*cachedCatalog
+}
+
+// get returns the response rendered for variant from the catalog generation, rendering it from the
+// services returned by list on first use.
+func (c *catalogCache) get(generation uint64, variant catalogVariant, list func() ([]osb.Service, error)) (*cachedCatalog, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if generation != c.generation || c.responses == nil {
+		c.generation = generation
+		c.responses = make(map[catalogVariant]*cachedCatalog)
+	}
+	if cached, ok := c.responses[variant]; ok {
+		return cached, nil
+	}
+	services, err := list()
+	if err != nil {
+		return nil, err
+	}
+	cached, err := renderCatalog(services, variant)
+	if err != nil {
+		return nil, err
+	}
+	c.responses[variant] = cached
+	return cached, nil
+}
+
+// renderCatalog renders the catalog response for variant. The services are copied, leaving the
+// catalog of the client untouched.
+func renderCatalog(services []osb.Service, variant catalogVariant) (*cachedCatalog, error) {
+	catalog := make([]osb.Service, len(services))
+	for i, service := range services {
+		service.BindingsRetrievable = variant.bindingsRetrievable
+		if !variant.parameterSchemas {
+			plans := make([]osb.Plan, len(service.Plans))
+			for j, plan := range service.Plans {
+				plan.ParameterSchemas = nil
+				plans[j] = plan
+			}
+			service.Plans = plans
+		}
+		catalog[i] = service
+	}
+	response := &broker.CatalogResponse{
+		CatalogResponse: osb.CatalogResponse{
+			Services: catalog,
+		},
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render the catalog: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	return &cachedCatalog{
+		response: response,
+		etag:     fmt.Sprintf("%q", hex.EncodeToString(sum[:16])),
+	}, nil
+}
+
+// catalog returns the catalog response for the API version of the request.
+func (b *Broker) catalog(c *broker.RequestContext) (*cachedCatalog, error) {
+	// The generation is read before the services are listed: a catalog built in between is cached
+	// under the older generation, and rendered again on the next request.
+	generation := b.client.CatalogGeneration()
+	// Service bindings can only be fetched, and plan schemas only read, by platforms supporting it.
+	version := requestAPIVersion(c)
+	variant := catalogVariant{
+		bindingsRetrievable: version.Supports(FeatureGetBinding),
+		parameterSchemas:    version.Supports(FeatureParameterSchemas),
+	}
+	cached, err := b.catalogCache.get(generation, variant, b.client.ListServices)
+	if err != nil {
+		return nil, minibroker.ToHTTPStatusCodeError(err)
+	}
+	return cached, nil
+}
+
+// CatalogMiddleware wraps next, tagging the catalog responses with an ETag and responding with
+// 304 Not Modified to the conditional requests for a catalog the platform already has. It
+// satisfies the mux.MiddlewareFunc type.
+func (b *Broker) CatalogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != catalogPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Invalid API versions and failures are left to the catalog handler to report.
+		if _, err := NegotiateAPIVersion(r.Header.Get(osb.APIVersionHeader)); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cached, err := b.catalog(&broker.RequestContext{Writer: w, Request: r})
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", osb.APIVersionHeader)
+		w.Header().Set("ETag", cached.etag)
+		if etagMatches(r.Header.Get("If-None-Match"), cached.etag) {
+			klog.V(4).Infoln("broker: catalog not modified")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), catalogContextKey{}, cached)))
+	})
+}
+
+// etagMatches returns whether the If-None-Match header matches etag. Weak comparison is used, as
+// required for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
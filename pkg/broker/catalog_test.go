@@ -0,0 +1,74 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	osbbroker "github.com/pmorie/osb-broker-lib/pkg/broker"
+
+	"github.com/kubernetes-sigs/minibroker/pkg/audit"
+	"github.com/kubernetes-sigs/minibroker/pkg/broker"
+	"github.com/kubernetes-sigs/minibroker/pkg/broker/mocks"
+)
+
+// syntheticServices returns a catalog of services with plans each.
+func syntheticServices(services, plans int) []osb.Service {
+	catalog := make([]osb.Service, services)
+	for i := range catalog {
+		catalog[i] = osb.Service{
+			ID:          fmt.Sprintf("service-%d", i),
+			Name:        fmt.Sprintf("service-%d", i),
+			Description: "A synthetic service",
+			Bindable:    true,
+			Plans:       make([]osb.Plan, plans),
+		}
+		for j := range catalog[i].Plans {
+			catalog[i].Plans[j] = osb.Plan{
+				ID:          fmt.Sprintf("service-%d-plan-%d", i, j),
+				Name:        fmt.Sprintf("plan-%d", j),
+				Description: "A synthetic plan",
+				Metadata:    map[string]interface{}{"bullets": []string{"Chart version 1.0.0"}},
+			}
+		}
+	}
+	return catalog
+}
+
+func BenchmarkGetCatalog(b *testing.B) {
+	ctrl := gomock.NewController(b)
+	defer ctrl.Finish()
+	mbclient := mocks.NewMockMinibrokerClient(ctrl)
+	mbclient.EXPECT().ListServices().Return(syntheticServices(500, 100), nil).AnyTimes()
+	mbclient.EXPECT().CatalogGeneration().Return(uint64(1)).AnyTimes()
+	brk := broker.NewBroker(mbclient, "namespace", &broker.ProvisioningSettings{}, audit.NewNoopTrail())
+
+	request := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+	request.Header.Set(osb.APIVersionHeader, "2.14")
+	c := &osbbroker.RequestContext{Request: request}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := brk.GetCatalog(c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
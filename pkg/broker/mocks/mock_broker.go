@@ -6,6 +6,7 @@ package mocks
 
 import (
 	gomock "github.com/golang/mock/gomock"
+	audit "github.com/kubernetes-sigs/minibroker/pkg/audit"
 	minibroker "github.com/kubernetes-sigs/minibroker/pkg/minibroker"
 	v2 "github.com/pmorie/go-open-service-broker-client/v2"
 	reflect "reflect"
@@ -35,33 +36,47 @@ func (m *MockMinibrokerClient) EXPECT() *MockMinibrokerClientMockRecorder {
 }
 
 // Bind mocks base method
-func (m *MockMinibrokerClient) Bind(arg0, arg1, arg2 string, arg3 bool, arg4 *minibroker.BindParams) (string, error) {
+func (m *MockMinibrokerClient) Bind(arg0, arg1, arg2 string, arg3 bool, arg4 *minibroker.BindParams, arg5 *audit.Identity) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Bind", arg0, arg1, arg2, arg3, arg4)
+	ret := m.ctrl.Call(m, "Bind", arg0, arg1, arg2, arg3, arg4, arg5)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Bind indicates an expected call of Bind
-func (mr *MockMinibrokerClientMockRecorder) Bind(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+func (mr *MockMinibrokerClientMockRecorder) Bind(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Bind", reflect.TypeOf((*MockMinibrokerClient)(nil).Bind), arg0, arg1, arg2, arg3, arg4)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Bind", reflect.TypeOf((*MockMinibrokerClient)(nil).Bind), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// CatalogGeneration mocks base method
+func (m *MockMinibrokerClient) CatalogGeneration() uint64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CatalogGeneration")
+	ret0, _ := ret[0].(uint64)
+	return ret0
+}
+
+// CatalogGeneration indicates an expected call of CatalogGeneration
+func (mr *MockMinibrokerClientMockRecorder) CatalogGeneration() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CatalogGeneration", reflect.TypeOf((*MockMinibrokerClient)(nil).CatalogGeneration))
 }
 
 // Deprovision mocks base method
-func (m *MockMinibrokerClient) Deprovision(arg0 string, arg1 bool) (string, error) {
+func (m *MockMinibrokerClient) Deprovision(arg0 string, arg1 bool, arg2 *audit.Identity) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Deprovision", arg0, arg1)
+	ret := m.ctrl.Call(m, "Deprovision", arg0, arg1, arg2)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Deprovision indicates an expected call of Deprovision
-func (mr *MockMinibrokerClientMockRecorder) Deprovision(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockMinibrokerClientMockRecorder) Deprovision(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Deprovision", reflect.TypeOf((*MockMinibrokerClient)(nil).Deprovision), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Deprovision", reflect.TypeOf((*MockMinibrokerClient)(nil).Deprovision), arg0, arg1, arg2)
 }
 
 // GetBinding mocks base method
@@ -139,18 +154,33 @@ func (mr *MockMinibrokerClientMockRecorder) ListServices() *gomock.Call {
 }
 
 // Provision mocks base method
-func (m *MockMinibrokerClient) Provision(arg0, arg1, arg2, arg3 string, arg4 bool, arg5 *minibroker.ProvisionParams) (string, error) {
+func (m *MockMinibrokerClient) Provision(arg0, arg1, arg2, arg3 string, arg4 bool, arg5 *minibroker.ProvisionParams, arg6 *audit.Identity) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Provision", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret := m.ctrl.Call(m, "Provision", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Provision indicates an expected call of Provision
-func (mr *MockMinibrokerClientMockRecorder) Provision(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+func (mr *MockMinibrokerClientMockRecorder) Provision(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Provision", reflect.TypeOf((*MockMinibrokerClient)(nil).Provision), arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+}
+
+// ServiceChart mocks base method
+func (m *MockMinibrokerClient) ServiceChart(arg0 string) (string, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ServiceChart", arg0)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// ServiceChart indicates an expected call of ServiceChart
+func (mr *MockMinibrokerClientMockRecorder) ServiceChart(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Provision", reflect.TypeOf((*MockMinibrokerClient)(nil).Provision), arg0, arg1, arg2, arg3, arg4, arg5)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ServiceChart", reflect.TypeOf((*MockMinibrokerClient)(nil).ServiceChart), arg0)
 }
 
 // Unbind mocks base method
@@ -16,8 +16,11 @@ limitations under the License.
 
 package broker
 
+import "time"
+
 type Options struct {
 	HelmRepoURL string
+	// The YAML or JSON file with the optional curated catalog.
 	CatalogPath string
 	// The namespace where Minibroker stores configmaps.
 	ConfigNamespace string
@@ -26,7 +29,16 @@ type Options struct {
 	ServiceCatalogEnabledOnly bool
 	// The YAML file where the optional default chart values are stored.
 	ProvisioningSettingsPath string
+	// The YAML or JSON file with the optional credential mappings of the charts.
+	CredentialMappingsPath string
+	// The directory with the optional provider plugins, executables named after the charts.
+	ProviderPluginDir string
+	// The time provider plugins have to produce the credentials.
+	ProviderPluginTimeout time.Duration
 	// The k8s cluster domain. If not set via the CLI flags, Minibroker tries to
 	// infer from the /etc/resolv.conf.
 	ClusterDomain string
+	// The path to the file where the audit trail is appended as JSON lines. "-" writes the audit
+	// trail to stdout. If not set, no audit trail is recorded.
+	AuditLogPath string
 }
@@ -0,0 +1,165 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// APIVersion represents an OSB API version sent by platforms in the X-Broker-API-Version header.
+type APIVersion struct {
+	Major int
+	Minor int
+}
+
+// The range of OSB API versions supported by Minibroker. Platforms sending a newer minor version
+// are served using MaxAPIVersion, as minor versions are backwards compatible.
+var (
+	MinAPIVersion = APIVersion{Major: 2, Minor: 11}
+	MaxAPIVersion = APIVersion{Major: 2, Minor: 15}
+)
+
+// Feature is an OSB API feature that depends on the negotiated API version.
+type Feature string
+
+// The version-dependent features known to Minibroker.
+const (
+	FeatureAsyncBindings        Feature = "async bindings"
+	FeatureGetBinding           Feature = "fetching service bindings"
+	FeatureGetInstance          Feature = "fetching service instances"
+	FeatureMaintenanceInfo      Feature = "maintenance_info"
+	FeatureBindingLastOperation Feature = "polling binding last operations"
+	FeatureParameterSchemas     Feature = "plan parameter schemas"
+)
+
+// featureVersions maps each feature to the first API version supporting it. Fetching service
+// instances and maintenance_info are not exposed by the osb-broker-lib API surface yet; they are
+// listed so that they are gated once served.
+var featureVersions = map[Feature]APIVersion{
+	FeatureAsyncBindings:        {Major: 2, Minor: 14},
+	FeatureGetBinding:           {Major: 2, Minor: 14},
+	FeatureGetInstance:          {Major: 2, Minor: 14},
+	FeatureMaintenanceInfo:      {Major: 2, Minor: 15},
+	FeatureBindingLastOperation: {Major: 2, Minor: 14},
+	FeatureParameterSchemas:     {Major: 2, Minor: 13},
+}
+
+// ParseAPIVersion parses a version in the "major.minor" form.
+func ParseAPIVersion(version string) (APIVersion, error) {
+	parts := strings.Split(strings.TrimSpace(version), ".")
+	if len(parts) != 2 {
+		return APIVersion{}, fmt.Errorf("invalid API version %q: expected the major.minor format", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major < 0 {
+		return APIVersion{}, fmt.Errorf("invalid API version %q: invalid major version", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil || minor < 0 {
+		return APIVersion{}, fmt.Errorf("invalid API version %q: invalid minor version", version)
+	}
+	return APIVersion{Major: major, Minor: minor}, nil
+}
+
+// String returns the version in the "major.minor" form.
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// AtLeast returns whether v is the same as or newer than other.
+func (v APIVersion) AtLeast(other APIVersion) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	return v.Minor >= other.Minor
+}
+
+// Supports returns whether the feature is available when v is the negotiated version.
+func (v APIVersion) Supports(feature Feature) bool {
+	required, ok := featureVersions[feature]
+	if !ok {
+		return false
+	}
+	return v.AtLeast(required)
+}
+
+// NegotiateAPIVersion validates the version requested by a platform against the supported range
+// and returns the version that Minibroker should speak. Unsupported versions result in a 412
+// Precondition Failed error, as required by the OSB spec.
+func NegotiateAPIVersion(version string) (APIVersion, error) {
+	requested, err := ParseAPIVersion(version)
+	if err != nil {
+		return APIVersion{}, preconditionFailed(err.Error())
+	}
+	if requested.Major != MaxAPIVersion.Major || !requested.AtLeast(MinAPIVersion) {
+		return APIVersion{}, preconditionFailed(fmt.Sprintf(
+			"unsupported API version %s: the supported versions range from %s to %s",
+			requested,
+			MinAPIVersion,
+			MaxAPIVersion,
+		))
+	}
+	if requested.AtLeast(MaxAPIVersion) {
+		return MaxAPIVersion, nil
+	}
+	return requested, nil
+}
+
+// requestAPIVersion returns the API version negotiated for the request. Requests without an HTTP
+// request attached, i.e. not coming from the API surface, are served with MaxAPIVersion.
+func requestAPIVersion(c *broker.RequestContext) APIVersion {
+	if c == nil || c.Request == nil {
+		return MaxAPIVersion
+	}
+	version, err := NegotiateAPIVersion(c.Request.Header.Get(osb.APIVersionHeader))
+	if err != nil {
+		// ValidateBrokerAPIVersion already rejected invalid versions before reaching this point.
+		return MinAPIVersion
+	}
+	return version
+}
+
+// requireFeature returns an error if the feature is not available for the request API version.
+func requireFeature(c *broker.RequestContext, feature Feature) error {
+	version := requestAPIVersion(c)
+	if version.Supports(feature) {
+		return nil
+	}
+	description := fmt.Sprintf(
+		"%s requires OSB API version %s or later, got %s",
+		feature,
+		featureVersions[feature],
+		version,
+	)
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusBadRequest,
+		Description: &description,
+	}
+}
+
+func preconditionFailed(description string) error {
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusPreconditionFailed,
+		Description: &description,
+	}
+}
@@ -114,6 +114,16 @@ func (cc *ChartClient) Install(
 	return rls, nil
 }
 
+// Load loads the chart archive of a chart version.
+func (cc *ChartClient) Load(chartDef *repo.ChartVersion) (*chart.Chart, error) {
+	if len(chartDef.URLs) == 0 {
+		err := fmt.Errorf("missing chart URL for %q", chartDef.Name)
+		return nil, fmt.Errorf("failed to load chart: %v", err)
+	}
+
+	return cc.chartLoader.Load(chartDef.URLs[0])
+}
+
 // Uninstall uninstalls a release from a namespace.
 func (cc *ChartClient) Uninstall(releaseName, namespace string) error {
 	uninstaller, err := cc.ChartHelmClientProvider.ProvideUninstaller(namespace)
@@ -253,6 +253,34 @@ var _ = Describe("Chart", func() {
 			})
 		})
 
+		Describe("Load", func() {
+			It("should fail when the chartDef.URLs is empty", func() {
+				client := helm.NewChartClient(log.NewNoop(), nil, nil, nil)
+				chartDef := &repo.ChartVersion{
+					Metadata: &chart.Metadata{Name: "foo"},
+					URLs:     make([]string, 0),
+				}
+				chrt, err := client.Load(chartDef)
+				Expect(err).To(Equal(fmt.Errorf("failed to load chart: missing chart URL for \"foo\"")))
+				Expect(chrt).To(BeNil())
+			})
+
+			It("should load the chart from the chart manager", func() {
+				chartURL := "https://foo/bar.tar.gz"
+				chartRequested := &chart.Chart{Schema: []byte(`{"type": "object"}`)}
+				chartLoader := mocks.NewMockChartLoader(ctrl)
+				chartLoader.EXPECT().
+					Load(chartURL).
+					Return(chartRequested, nil).
+					Times(1)
+				client := helm.NewChartClient(log.NewNoop(), chartLoader, nil, nil)
+				chartDef := &repo.ChartVersion{URLs: []string{chartURL}}
+				chrt, err := client.Load(chartDef)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(chrt).To(Equal(chartRequested))
+			})
+		})
+
 		Describe("Uninstall", func() {
 			It("should fail when getting the helm uninstaller client fails", func() {
 				releaseName := "foo-12345"
@@ -0,0 +1,188 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The annotations declaring the credential roles of the Services and Secrets of charts without a
+// Provider. Charts, or post-renderers, set them on the resources they create.
+const (
+	// CredentialsAnnotationPrefix prefixes the credential annotations.
+	CredentialsAnnotationPrefix = "credentials.minibroker/"
+	// HostAnnotation set to "true" marks the Service clients connect to.
+	HostAnnotation = CredentialsAnnotationPrefix + "host"
+	// PortAnnotation names the port of the host Service clients connect to. The first port is used
+	// when not set.
+	PortAnnotation = CredentialsAnnotationPrefix + "port"
+	// SchemeAnnotation sets the protocol and the URI scheme of the credentials. It is read from the
+	// host Service, then from the Secrets.
+	SchemeAnnotation = CredentialsAnnotationPrefix + "scheme"
+	// UsernameKeyAnnotation names the key of the Secret holding the username.
+	UsernameKeyAnnotation = CredentialsAnnotationPrefix + "username-key"
+	// UsernameAnnotation sets the username when no Secret holds it.
+	UsernameAnnotation = CredentialsAnnotationPrefix + "username"
+	// PasswordKeyAnnotation names the key of the Secret holding the password.
+	PasswordKeyAnnotation = CredentialsAnnotationPrefix + "password-key"
+)
+
+// hasCredentialAnnotations returns whether any of the resources declares credential roles.
+func hasCredentialAnnotations(services []corev1.Service, secrets []corev1.Secret) bool {
+	for _, service := range services {
+		if hasAnnotationPrefix(service.Annotations, CredentialsAnnotationPrefix) {
+			return true
+		}
+	}
+	for _, secret := range secrets {
+		if hasAnnotationPrefix(secret.Annotations, CredentialsAnnotationPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnnotationPrefix(annotations map[string]string, prefix string) bool {
+	for key := range annotations {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnnotationProvider is the Provider building the credentials of charts without a dedicated
+// Provider from the credential annotations of their Services and Secrets.
+type AnnotationProvider struct {
+	hostBuilder
+	// The Secrets of the instance, as their annotations are not part of the chart secrets.
+	secrets []corev1.Secret
+}
+
+func (p AnnotationProvider) Bind(
+	services []corev1.Service,
+	_ *BindParams,
+	_ *ProvisionParams,
+	_ Object,
+) (Object, error) {
+	service, err := annotatedHostService(services)
+	if err != nil {
+		return nil, err
+	}
+	svcPort, err := annotatedPort(service)
+	if err != nil {
+		return nil, err
+	}
+	host := p.hostFromService(service)
+
+	creds := Object{
+		"host": host,
+		"port": svcPort.Port,
+	}
+	scheme := service.Annotations[SchemeAnnotation]
+	var username, password *string
+	for _, secret := range p.secrets {
+		if scheme == "" {
+			scheme = secret.Annotations[SchemeAnnotation]
+		}
+		if value, ok := secret.Annotations[UsernameAnnotation]; ok && username == nil {
+			username = &value
+		}
+		if key, ok := secret.Annotations[UsernameKeyAnnotation]; ok {
+			value, err := annotatedSecretValue(&secret, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get username: %w", err)
+			}
+			username = &value
+		}
+		if key, ok := secret.Annotations[PasswordKeyAnnotation]; ok {
+			value, err := annotatedSecretValue(&secret, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get password: %w", err)
+			}
+			password = &value
+		}
+	}
+	if username != nil {
+		creds["username"] = *username
+	}
+	if password != nil {
+		creds["password"] = *password
+	}
+	if scheme != "" {
+		creds["protocol"] = scheme
+		uri := &url.URL{
+			Scheme: scheme,
+			Host:   fmt.Sprintf("%s:%d", host, svcPort.Port),
+		}
+		switch {
+		case password != nil && username != nil:
+			uri.User = url.UserPassword(*username, *password)
+		case password != nil:
+			uri.User = url.UserPassword("", *password)
+		case username != nil:
+			uri.User = url.User(*username)
+		}
+		creds["uri"] = uri.String()
+	}
+
+	return creds, nil
+}
+
+// annotatedHostService returns the Service annotated as the host, or the only Service.
+func annotatedHostService(services []corev1.Service) (*corev1.Service, error) {
+	for i := range services {
+		if services[i].Annotations[HostAnnotation] == "true" {
+			return &services[i], nil
+		}
+	}
+	if len(services) == 1 {
+		return &services[0], nil
+	}
+	return nil, errors.Errorf("could not identify the service: none of the services is annotated with %s", HostAnnotation)
+}
+
+// annotatedPort returns the port of the service named by its port annotation, or its first port.
+func annotatedPort(service *corev1.Service) (*corev1.ServicePort, error) {
+	if len(service.Spec.Ports) == 0 {
+		return nil, errors.Errorf("no ports found")
+	}
+	name, ok := service.Annotations[PortAnnotation]
+	if !ok {
+		return &service.Spec.Ports[0], nil
+	}
+	for i := range service.Spec.Ports {
+		if service.Spec.Ports[i].Name == name {
+			return &service.Spec.Ports[i], nil
+		}
+	}
+	return nil, errors.Errorf("port %q not found in service %q", name, service.Name)
+}
+
+// annotatedSecretValue returns the value of the key of the secret named by an annotation.
+func annotatedSecretValue(secret *corev1.Secret, key string) (string, error) {
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", errors.Errorf("key %q not found in secret %q", key, secret.Name)
+	}
+	return string(value), nil
+}
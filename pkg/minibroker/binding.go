@@ -0,0 +1,172 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// BindingHooks is implemented by the Providers creating resources for each binding, e.g. a database
+// user, so that the access of a binding can be revoked without affecting the others.
+type BindingHooks interface {
+	// OnBind creates the resources of the binding. It returns the credentials of the binding, given
+	// the credentials returned by Bind.
+	OnBind(binding *Binding, creds Object) (Object, error)
+	// OnUnbind deletes the resources of the binding, given the credentials returned by OnBind.
+	OnUnbind(binding *Binding, creds Object) error
+}
+
+// BindParamsValidator is implemented by the Providers reading the binding parameters, so that
+// invalid parameters are rejected before binding.
+type BindParamsValidator interface {
+	ValidateBindParams(bindParams *BindParams) []FieldError
+}
+
+// Binding is a binding of an instance, as given to BindingHooks.
+type Binding struct {
+	ID              string
+	Services        []corev1.Service
+	BindParams      *BindParams
+	ProvisionParams *ProvisionParams
+	ChartSecrets    Object
+}
+
+// The binding parameters requesting a dedicated database user and its access.
+const (
+	dedicatedUserParam = "dedicatedUser"
+	accessParam        = "access"
+
+	readWriteAccess = "read-write"
+	readOnlyAccess  = "read-only"
+)
+
+// sqlTimeout limits the time taken by the statements managing the users of a binding.
+const sqlTimeout = 30 * time.Second
+
+// sqlOpen opens the database connections managing the users of the bindings.
+var sqlOpen = sql.Open
+
+// validateDedicatedUserParams checks the binding parameters requesting a dedicated database user.
+func validateDedicatedUserParams(bindParams *BindParams) []FieldError {
+	var fieldErrors []FieldError
+	dedicated, ok := bindParams.Dig(dedicatedUserParam)
+	if _, isBool := dedicated.(bool); ok && !isBool {
+		fieldErrors = append(fieldErrors, FieldError{Field: dedicatedUserParam, Description: "must be a boolean"})
+	}
+	if access, ok := bindParams.Dig(accessParam); ok {
+		if access != readWriteAccess && access != readOnlyAccess {
+			fieldErrors = append(fieldErrors, FieldError{Field: accessParam, Description: fmt.Sprintf("must be %s or %s", readWriteAccess, readOnlyAccess)})
+		} else if dedicated != true {
+			fieldErrors = append(fieldErrors, FieldError{Field: accessParam, Description: fmt.Sprintf("requires %s", dedicatedUserParam)})
+		}
+	}
+	return fieldErrors
+}
+
+// dedicatedUserAccess returns the access of the dedicated database user requested by the binding
+// parameters, if any.
+func dedicatedUserAccess(bindParams *BindParams) (string, bool) {
+	if bindParams == nil {
+		return "", false
+	}
+	if dedicated, _ := bindParams.Dig(dedicatedUserParam); dedicated != true {
+		return "", false
+	}
+	access, err := bindParams.DigStringOr(accessParam, readWriteAccess)
+	if err != nil {
+		return "", false
+	}
+	return access, true
+}
+
+// bindingUsername returns the name of the dedicated database user of a binding. It is derived from
+// the binding ID, fitting the 32 characters allowed by MySQL.
+func bindingUsername(bindingID string) string {
+	sum := sha256.Sum256([]byte(bindingID))
+	return "binding_" + hex.EncodeToString(sum[:8])
+}
+
+// generatePassword returns a random password.
+func generatePassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate a password: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// withUser returns the credentials with the user replaced, in the uri and read_uri credentials too.
+func withUser(creds Object, username, password string) (Object, error) {
+	userCreds := make(Object, len(creds))
+	for k, v := range creds {
+		userCreds[k] = v
+	}
+	userCreds["username"] = username
+	userCreds["password"] = password
+	for _, key := range []string{"uri", "read_uri"} {
+		rawURI, err := creds.DigString(key)
+		if err != nil {
+			continue
+		}
+		uri, err := url.Parse(rawURI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the %s: %v", key, err)
+		}
+		uri.User = url.UserPassword(username, password)
+		userCreds[key] = uri.String()
+	}
+	return userCreds, nil
+}
+
+// execStatements runs the statements in order on the database at dsn.
+func execStatements(driver, dsn string, statements []string) error {
+	db, err := sqlOpen(driver, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlTimeout)
+	defer cancel()
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createDedicatedUser runs the statements creating a dedicated user, running the statements dropping
+// it if they fail, so that binding can be retried.
+func createDedicatedUser(driver, dsn string, create, drop []string) error {
+	if err := execStatements(driver, dsn, create); err != nil {
+		if dropErr := execStatements(driver, dsn, drop); dropErr != nil {
+			return fmt.Errorf("failed to create the user of the binding: %v (the user could not be dropped: %v)", err, dropErr)
+		}
+		return fmt.Errorf("failed to create the user of the binding: %v", err)
+	}
+	return nil
+}
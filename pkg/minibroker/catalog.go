@@ -0,0 +1,574 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/ghodss/yaml"
+	"github.com/google/uuid"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"helm.sh/helm/v3/pkg/repo"
+	klog "k8s.io/klog/v2"
+)
+
+// CatalogConfig is a curated catalog. It selects the charts offered as services and overrides the
+// catalog entries generated from the Helm repository index.
+type CatalogConfig struct {
+	// The curated services. Only the listed charts are offered; when empty, the catalog is generated
+	// from the Helm repository index.
+	Services []CatalogServiceConfig `json:"services,omitempty"`
+	// The templates rendering the service and plan metadata from the charts.
+	MetadataTemplates *MetadataTemplates `json:"metadataTemplates,omitempty"`
+	// The filter of the services without their own filter.
+	Filter *CatalogFilter `json:"filter,omitempty"`
+	// Adds a latest plan to every service without its own setting.
+	LatestPlan bool `json:"latestPlan,omitempty"`
+	// The JSON schemas of the chart values, by chart name, published as the plan schemas instead of
+	// the values.schema.json of the charts.
+	ParameterSchemas map[string]map[string]interface{} `json:"parameterSchemas,omitempty"`
+	// Publishes the values.schema.json of the charts as the plan schemas. The chart versions backing
+	// the plans are downloaded in the background when Minibroker starts, and their schemas published
+	// once loaded.
+	PublishChartSchemas bool `json:"publishChartSchemas,omitempty"`
+}
+
+// CatalogServiceConfig curates the service offered for a chart. Unset fields keep the values
+// generated from the Helm repository index.
+type CatalogServiceConfig struct {
+	// The name of the chart in the Helm repository.
+	Chart       string   `json:"chart"`
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// The metadata fields set over the ones rendered from the chart.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// The curated plans. When empty, a plan is generated for each app version of the chart.
+	Plans []CatalogPlanConfig `json:"plans,omitempty"`
+	// The filter of the service, replacing the catalog filter.
+	Filter *CatalogFilter `json:"filter,omitempty"`
+	// Adds a latest plan to the service, backed by the highest app version accepted by the filter.
+	LatestPlan *bool `json:"latestPlan,omitempty"`
+	// Overrides whether the service is bindable, which by default depends on whether minibroker
+	// knows how to produce the credentials of the chart.
+	Bindable *bool `json:"bindable,omitempty"`
+}
+
+// CatalogPlanConfig curates a plan of a service. A plan is backed by the chart version pinned by
+// ChartVersion or, when not set, by the latest chart version packaging AppVersion.
+type CatalogPlanConfig struct {
+	Name         string `json:"name"`
+	Description  string `json:"description,omitempty"`
+	AppVersion   string `json:"appVersion,omitempty"`
+	ChartVersion string `json:"chartVersion,omitempty"`
+	Free         *bool  `json:"free,omitempty"`
+	// The metadata fields set over the ones rendered from the chart.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+
+	// token identifies the plan when deriving its IDs. It defaults to the plan name; generated plans
+	// use their app version.
+	token string
+	// latest is set for the latest plan.
+	latest bool
+	// legacy is set for the generated plans, which older releases offered under legacy IDs.
+	legacy bool
+}
+
+// LoadCatalogConfig parses a curated catalog in the YAML or JSON format.
+func LoadCatalogConfig(data []byte) (*CatalogConfig, error) {
+	var config CatalogConfig
+	if err := yaml.Unmarshal(data, &config, yaml.DisallowUnknownFields); err != nil {
+		return nil, fmt.Errorf("failed to load catalog: %v", err)
+	}
+	return &config, nil
+}
+
+// catalog holds the services offered by the broker and the chart versions backing their plans.
+// Besides the IDs listed in the services, the legacy IDs of older releases are mapped, so that
+// instances created with them can still be managed.
+type catalog struct {
+	// The generation of the catalog, which changes every time the catalog is built.
+	generation uint64
+	services   []osb.Service
+	// The chart names by service ID.
+	charts map[string]string
+	// The plans by plan ID.
+	plans map[string]catalogPlan
+	// The operator-supplied values schemas, by chart name.
+	chartSchemas map[string]*valuesSchema
+	// The values schemas of the chart versions backing the plans, shared by the generations of the
+	// catalog.
+	valuesSchemas *schemaCache
+}
+
+// catalogPlan is the chart version backing a plan and the tier sizing it, if any.
+type catalogPlan struct {
+	chart        string
+	chartVersion *repo.ChartVersion
+	tier         *Tier
+	// latest is set for the latest plan. Its chart version is the latest one of the index loaded
+	// when Minibroker starts.
+	latest bool
+}
+
+// chart returns the name of the chart backing a service.
+func (c *catalog) chart(serviceID string) (string, bool) {
+	chart, ok := c.charts[serviceID]
+	return chart, ok
+}
+
+// plan returns a plan of a service.
+func (c *catalog) plan(serviceID, planID string) (catalogPlan, bool) {
+	plan, ok := c.plans[planID]
+	if !ok || plan.chart != c.charts[serviceID] {
+		return catalogPlan{}, false
+	}
+	return plan, true
+}
+
+// catalogNamespace is the namespace of the name-based UUIDs generated for services and plans.
+var catalogNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/kubernetes-sigs/minibroker"))
+
+// makeServiceID derives a stable service ID from the chart name. The repository URL is left out,
+// so that pointing Minibroker to a mirror of the repository doesn't orphan the instances.
+func makeServiceID(chart string) string {
+	return uuid.NewSHA1(catalogNamespace, []byte(chart)).String()
+}
+
+// makePlanID derives a stable plan ID from the chart name and the token identifying the plan.
+func makePlanID(chart, token string) string {
+	return uuid.NewSHA1(catalogNamespace, []byte(strings.Join([]string{chart, token}, "\x00"))).String()
+}
+
+var planIDCleaner = regexp.MustCompile(`[^a-z0-9]`)
+
+// makeLegacyPlanID derives a plan ID the way older releases did, e.g. redis@5.0.7 becomes
+// redis-5-0-7. These IDs are not reversible, as dashes can't be told apart from dots.
+func makeLegacyPlanID(chart, token string) string {
+	return planIDCleaner.ReplaceAllString(strings.ToLower(fmt.Sprintf("%s@%s", chart, token)), "-")
+}
+
+// catalogBuilder builds the catalog from the charts of a Helm repository index.
+type catalogBuilder struct {
+	charts map[string]repo.ChartVersions
+	// The tiers by chart name.
+	tiers map[string][]Tier
+	// offered filters the charts listed without a curated catalog.
+	offered func(chart string) bool
+	// bindable returns whether the credentials of a chart can be produced.
+	bindable func(chart string) bool
+	// The catalog filter, compiled for the cluster version.
+	filter      *chartFilter
+	kubeVersion string
+	// Whether services without their own setting have a latest plan.
+	latestPlan bool
+	metadata   *metadataRenderer
+	catalog    *catalog
+}
+
+// buildCatalog builds the catalog from the charts of a Helm repository index. When config is nil or
+// lists no services, every chart accepted by offered is listed with a plan for each app version;
+// otherwise, the curated catalog is validated against the index and used. The plans of charts with
+// tiers are offered once per tier. kubeVersion is the version of the cluster the filters match the
+// charts against, if known. Services are bindable when bindable accepts their chart, unless the
+// curated catalog overrides it.
+func buildCatalog(
+	charts map[string]repo.ChartVersions,
+	config *CatalogConfig,
+	tiers map[string][]Tier,
+	kubeVersion string,
+	offered func(chart string) bool,
+	bindable func(chart string) bool,
+) (*catalog, error) {
+	if config == nil {
+		config = &CatalogConfig{}
+	}
+	metadata, err := newMetadataRenderer(config.MetadataTemplates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog: %v", err)
+	}
+	filter, err := newChartFilter(config.Filter, kubeVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog: %v", err)
+	}
+	b := &catalogBuilder{
+		charts:      charts,
+		tiers:       tiers,
+		offered:     offered,
+		bindable:    bindable,
+		filter:      filter,
+		kubeVersion: kubeVersion,
+		latestPlan:  config.LatestPlan,
+		metadata:    metadata,
+		catalog: &catalog{
+			charts: make(map[string]string),
+			plans:  make(map[string]catalogPlan),
+		},
+	}
+	if len(config.Services) == 0 {
+		if err := b.addCharts(); err != nil {
+			return nil, fmt.Errorf("invalid catalog: %v", err)
+		}
+	} else {
+		for _, serviceConfig := range config.Services {
+			if err := b.addCuratedService(serviceConfig); err != nil {
+				return nil, fmt.Errorf("invalid catalog: %v", err)
+			}
+		}
+	}
+	return b.catalog, nil
+}
+
+// addCharts adds a service for each offered chart accepted by the catalog filter.
+func (b *catalogBuilder) addCharts() error {
+	chartNames := make([]string, 0, len(b.charts))
+	for chart := range b.charts {
+		chartNames = append(chartNames, chart)
+	}
+	sort.Strings(chartNames)
+
+	for _, chart := range chartNames {
+		if !b.offered(chart) || !b.filter.acceptsChart(latestChartVersion(b.charts[chart])) {
+			continue
+		}
+		if err := b.addService(CatalogServiceConfig{Chart: chart}, b.filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addCuratedService validates and adds a service of the curated catalog. Services left without
+// plans by their filter are skipped.
+func (b *catalogBuilder) addCuratedService(config CatalogServiceConfig) error {
+	if config.Chart == "" {
+		return fmt.Errorf("a chart is required for every service")
+	}
+	if _, ok := b.charts[config.Chart]; !ok {
+		return fmt.Errorf("chart %q not found in the Helm repository", config.Chart)
+	}
+	if _, ok := b.catalog.charts[config.Chart]; ok {
+		return fmt.Errorf("chart %q is listed more than once", config.Chart)
+	}
+	filter := b.filter
+	if config.Filter != nil {
+		var err error
+		if filter, err = newChartFilter(config.Filter, b.kubeVersion); err != nil {
+			return fmt.Errorf("chart %q: %v", config.Chart, err)
+		}
+	}
+	if !filter.acceptsChart(latestChartVersion(b.charts[config.Chart])) {
+		klog.V(3).Infof("minibroker: skipping chart %q because of its keywords", config.Chart)
+		return nil
+	}
+	if err := b.addService(config, filter); err != nil {
+		return err
+	}
+	if _, ok := b.catalog.charts[config.Chart]; !ok {
+		if filter != nil {
+			klog.V(3).Infof("minibroker: skipping chart %q because no versions match its filter", config.Chart)
+			return nil
+		}
+		return fmt.Errorf("chart %q has no versions with an app version and a valid semver", config.Chart)
+	}
+	return nil
+}
+
+// addService adds the service for a chart, skipping charts without plans. The filter selects the
+// chart versions backing the generated plans.
+func (b *catalogBuilder) addService(config CatalogServiceConfig, filter *chartFilter) error {
+	chart := config.Chart
+	chartVersions := b.charts[chart]
+	tiers := b.tiers[chart]
+	if err := validateTiers(chart, tiers); err != nil {
+		return err
+	}
+
+	svc := osb.Service{
+		ID:          makeServiceID(chart),
+		Name:        chart,
+		Description: "Helm Chart for " + chart,
+		Bindable:    b.bindable(chart),
+		Tags:        getTagIntersection(chartVersions),
+	}
+	if config.Bindable != nil {
+		svc.Bindable = *config.Bindable
+	}
+	if config.Name != "" {
+		svc.Name = config.Name
+	}
+	if config.Description != "" {
+		svc.Description = config.Description
+	}
+	if config.Tags != nil {
+		svc.Tags = config.Tags
+	}
+
+	plans := config.Plans
+	if len(plans) == 0 {
+		plans = filter.limitPlans(defaultPlans(chart, filter.filterChartVersions(chartVersions)))
+	}
+	latestPlanEnabled := b.latestPlan
+	if config.LatestPlan != nil {
+		latestPlanEnabled = *config.LatestPlan
+	}
+	if latestPlanEnabled {
+		if latest, ok := latestPlan(filter.filterChartVersions(chartVersions)); ok {
+			plans = append([]CatalogPlanConfig{latest}, plans...)
+		}
+	}
+	newPlans := make(map[string]catalogPlan)
+	planNames := make(map[string]bool)
+	for _, planConfig := range plans {
+		chartVersion, err := resolvePlanChartVersion(chart, chartVersions, planConfig)
+		if err != nil {
+			return err
+		}
+		token := planConfig.token
+		if token == "" {
+			token = planConfig.Name
+		}
+		plan := osb.Plan{
+			ID:          makePlanID(chart, token),
+			Name:        planConfig.Name,
+			Description: planConfig.Description,
+			Free:        planConfig.Free,
+		}
+		if plan.Name == "" {
+			return fmt.Errorf("a name is required for every plan of chart %q", chart)
+		}
+		if plan.Description == "" {
+			plan.Description = chartVersion.Description
+		}
+		if plan.Free == nil {
+			plan.Free = boolPtr(true)
+		}
+		newPlans[plan.ID] = catalogPlan{chart: chart, chartVersion: chartVersion, latest: planConfig.latest}
+		if planConfig.legacy {
+			// Legacy IDs are not unique, e.g. redis@a-b and redis-a@b share one: the first plan keeps it.
+			legacyID := makeLegacyPlanID(chart, token)
+			existing, taken := b.catalog.plans[legacyID]
+			if !taken {
+				existing, taken = newPlans[legacyID]
+			}
+			if taken {
+				klog.Warningf("minibroker: not mapping the legacy ID %q to plan %q of chart %q, as it maps to a plan of chart %q", legacyID, plan.Name, chart, existing.chart)
+			} else {
+				newPlans[legacyID] = newPlans[plan.ID]
+			}
+		}
+
+		var tieredPlans []osb.Plan
+		if len(tiers) == 0 {
+			if plan.Metadata, err = b.planMetadata(svc.Name, plan.Name, chartVersion, nil, planConfig); err != nil {
+				return err
+			}
+			tieredPlans = append(tieredPlans, plan)
+		} else {
+			// The untiered plan is no longer listed, but its IDs still map to it.
+			for i := range tiers {
+				tier := &tiers[i]
+				tieredPlan := plan
+				tieredPlan.ID = makePlanID(chart, token+"\x00"+tier.Name)
+				tieredPlan.Name = plan.Name + "-" + tier.Name
+				if tier.Description != "" {
+					tieredPlan.Description = fmt.Sprintf("%s (%s)", plan.Description, tier.Description)
+				}
+				if tieredPlan.Metadata, err = b.planMetadata(svc.Name, tieredPlan.Name, chartVersion, tier, planConfig); err != nil {
+					return err
+				}
+				newPlans[tieredPlan.ID] = catalogPlan{chart: chart, chartVersion: chartVersion, tier: tier, latest: planConfig.latest}
+				tieredPlans = append(tieredPlans, tieredPlan)
+			}
+		}
+		for _, p := range tieredPlans {
+			if planNames[p.Name] {
+				return fmt.Errorf("plan %q of chart %q is listed more than once", p.Name, chart)
+			}
+			planNames[p.Name] = true
+		}
+		svc.Plans = append(svc.Plans, tieredPlans...)
+	}
+
+	if len(svc.Plans) == 0 {
+		return nil
+	}
+	metadata, err := b.metadata.serviceMetadata(svc.Name, latestChartVersion(chartVersions))
+	if err != nil {
+		return fmt.Errorf("service %q: %v", svc.Name, err)
+	}
+	svc.Metadata = mergeMetadata(metadata, config.Metadata)
+	b.catalog.services = append(b.catalog.services, svc)
+	b.catalog.charts[svc.ID] = chart
+	b.catalog.charts[chart] = chart
+	for id, plan := range newPlans {
+		b.catalog.plans[id] = plan
+	}
+	return nil
+}
+
+// planMetadata renders the metadata of a plan, setting the curated fields over it.
+func (b *catalogBuilder) planMetadata(
+	service, name string,
+	chartVersion *repo.ChartVersion,
+	tier *Tier,
+	config CatalogPlanConfig,
+) (map[string]interface{}, error) {
+	metadata, err := b.metadata.planMetadata(service, name, chartVersion, tier, config.latest)
+	if err != nil {
+		return nil, fmt.Errorf("plan %q: %v", name, err)
+	}
+	return mergeMetadata(metadata, config.Metadata), nil
+}
+
+// latestChartVersion returns the chart version with the highest semver, or the first one when
+// none is valid.
+func latestChartVersion(chartVersions repo.ChartVersions) *repo.ChartVersion {
+	var latest *repo.ChartVersion
+	var latestV *semver.Version
+	for _, chartVersion := range chartVersions {
+		curV, err := semver.NewVersion(chartVersion.Version)
+		if err != nil {
+			continue
+		}
+		if latest == nil || curV.GreaterThan(latestV) {
+			latest, latestV = chartVersion, curV
+		}
+	}
+	if latest == nil {
+		return chartVersions[0]
+	}
+	return latest
+}
+
+// defaultPlans generates a plan for each app version of a chart, backed by the latest chart
+// version packaging it. Plans are ordered as the chart versions in the index.
+func defaultPlans(chart string, chartVersions repo.ChartVersions) []CatalogPlanConfig {
+	var appVersions []string
+	latest := map[string]*semver.Version{}
+	for _, chartVersion := range chartVersions {
+		if chartVersion.AppVersion == "" {
+			continue
+		}
+
+		curV, err := semver.NewVersion(chartVersion.Version)
+		if err != nil {
+			klog.V(4).Infof("minibroker: skipping %s@%s because %q is not a valid semver", chart, chartVersion.AppVersion, chartVersion.Version)
+			continue
+		}
+
+		maxV, ok := latest[chartVersion.AppVersion]
+		if !ok {
+			appVersions = append(appVersions, chartVersion.AppVersion)
+		} else if !curV.GreaterThan(maxV) {
+			klog.V(4).Infof("minibroker: skipping %s@%s because %s < %s", chart, chartVersion.AppVersion, curV, maxV)
+			continue
+		}
+		latest[chartVersion.AppVersion] = curV
+	}
+
+	plans := make([]CatalogPlanConfig, 0, len(appVersions))
+	for _, appVersion := range appVersions {
+		plans = append(plans, CatalogPlanConfig{
+			Name:         planIDCleaner.ReplaceAllString(appVersion, "-"),
+			AppVersion:   appVersion,
+			ChartVersion: latest[appVersion].Original(),
+			token:        appVersion,
+			legacy:       true,
+		})
+	}
+	return plans
+}
+
+// latestPlanName is the name of the latest plan of a service.
+const latestPlanName = "latest"
+
+// latestPlan generates the plan backed by the latest chart version packaging the highest app
+// version. Chart versions without a semver app version and chart version are not considered.
+func latestPlan(chartVersions repo.ChartVersions) (CatalogPlanConfig, bool) {
+	var latest *repo.ChartVersion
+	var latestAppV, latestV *semver.Version
+	for _, chartVersion := range chartVersions {
+		appV, err := semver.NewVersion(chartVersion.AppVersion)
+		if err != nil {
+			continue
+		}
+		curV, err := semver.NewVersion(chartVersion.Version)
+		if err != nil {
+			continue
+		}
+		if latest == nil || appV.GreaterThan(latestAppV) || (appV.Equal(latestAppV) && curV.GreaterThan(latestV)) {
+			latest, latestAppV, latestV = chartVersion, appV, curV
+		}
+	}
+	if latest == nil {
+		return CatalogPlanConfig{}, false
+	}
+	return CatalogPlanConfig{
+		Name:         latestPlanName,
+		Description:  fmt.Sprintf("The latest version of %s, currently %s", latest.Name, latest.AppVersion),
+		AppVersion:   latest.AppVersion,
+		ChartVersion: latest.Version,
+		token:        latestPlanName,
+		latest:       true,
+	}, true
+}
+
+// resolvePlanChartVersion finds the chart version backing a plan.
+func resolvePlanChartVersion(
+	chart string,
+	chartVersions repo.ChartVersions,
+	config CatalogPlanConfig,
+) (*repo.ChartVersion, error) {
+	if config.ChartVersion == "" && config.AppVersion == "" {
+		return nil, fmt.Errorf("plan %q of chart %q must set an app version or a chart version", config.Name, chart)
+	}
+
+	var resolved *repo.ChartVersion
+	var resolvedV *semver.Version
+	for _, chartVersion := range chartVersions {
+		if config.ChartVersion != "" && chartVersion.Version != config.ChartVersion {
+			continue
+		}
+		if config.AppVersion != "" && chartVersion.AppVersion != config.AppVersion {
+			continue
+		}
+		curV, err := semver.NewVersion(chartVersion.Version)
+		if err != nil {
+			continue
+		}
+		if resolved == nil || curV.GreaterThan(resolvedV) {
+			resolved, resolvedV = chartVersion, curV
+		}
+	}
+
+	if resolved == nil {
+		switch {
+		case config.ChartVersion != "" && config.AppVersion != "":
+			return nil, fmt.Errorf("plan %q: chart %q has no version %s with app version %s", config.Name, chart, config.ChartVersion, config.AppVersion)
+		case config.ChartVersion != "":
+			return nil, fmt.Errorf("plan %q: chart %q has no version %s", config.Name, chart, config.ChartVersion)
+		default:
+			return nil, fmt.Errorf("plan %q: chart %q has no version with app version %s", config.Name, chart, config.AppVersion)
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,612 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func testChartVersion(name, version, appVersion string) *repo.ChartVersion {
+	return &repo.ChartVersion{
+		Metadata: &chart.Metadata{
+			Name:        name,
+			Version:     version,
+			AppVersion:  appVersion,
+			Description: name + " " + version,
+			Keywords:    []string{name},
+		},
+	}
+}
+
+func testCharts() map[string]repo.ChartVersions {
+	return map[string]repo.ChartVersions{
+		"redis": {
+			testChartVersion("redis", "10.5.7", "5.0.7"),
+			testChartVersion("redis", "10.5.6", "5.0.7"),
+			testChartVersion("redis", "9.0.0", "4.0.14"),
+			testChartVersion("redis", "not-semver", "4.0.0"),
+			testChartVersion("redis", "8.0.0", ""),
+		},
+		"wordpress": {
+			testChartVersion("wordpress", "9.0.3", "5.3.2"),
+		},
+	}
+}
+
+func offerAll(string) bool { return true }
+
+func bindAll(string) bool { return true }
+
+func planIDs(services []osb.Service) []string {
+	var ids []string
+	for _, service := range services {
+		for _, plan := range service.Plans {
+			ids = append(ids, plan.ID)
+		}
+	}
+	return ids
+}
+
+func TestBuildCatalogFromIndex(t *testing.T) {
+	catalog, err := buildCatalog(testCharts(), nil, nil, "", func(chart string) bool { return chart == "redis" }, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+
+	if len(catalog.services) != 1 || catalog.services[0].ID != "93a902d0-9687-538c-805c-fba14e4949d9" {
+		t.Fatalf("buildCatalog: expected only the redis service, actual %+v", catalog.services)
+	}
+	service := catalog.services[0]
+	if service.Name != "redis" || service.Description != "Helm Chart for redis" || !service.Bindable {
+		t.Errorf("buildCatalog: unexpected service %+v", service)
+	}
+
+	expectedPlans := []osb.Plan{
+		{
+			ID:          "dc3e010b-0a87-55cc-99ef-de0178d79c96",
+			Name:        "5-0-7",
+			Description: "redis 10.5.7",
+			Free:        boolPtr(true),
+			Metadata: map[string]interface{}{
+				"displayName": "Redis 5.0.7",
+				"bullets":     []string{"Chart version 10.5.7", "App version 5.0.7"},
+			},
+		},
+		{
+			ID:          makePlanID("redis", "4.0.14"),
+			Name:        "4-0-14",
+			Description: "redis 9.0.0",
+			Free:        boolPtr(true),
+			Metadata: map[string]interface{}{
+				"displayName": "Redis 4.0.14",
+				"bullets":     []string{"Chart version 9.0.0", "App version 4.0.14"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(service.Plans, expectedPlans) {
+		t.Errorf("buildCatalog: expected plans %+v, actual %+v", expectedPlans, service.Plans)
+	}
+
+	if chart, ok := catalog.chart(service.ID); !ok || chart != "redis" {
+		t.Errorf("catalog.chart(%s): expected redis, actual %q", service.ID, chart)
+	}
+	plan, ok := catalog.plan(service.ID, service.Plans[0].ID)
+	if !ok || plan.chartVersion.Version != "10.5.7" {
+		t.Errorf("catalog.plan(%s, %s): expected chart version 10.5.7, actual %v", service.ID, service.Plans[0].ID, plan.chartVersion)
+	}
+	if _, ok := catalog.plan("wordpress", service.Plans[0].ID); ok {
+		t.Errorf("catalog.plan(wordpress, %s): expected the plan to belong to redis only", service.Plans[0].ID)
+	}
+}
+
+func TestCatalogLegacyIDs(t *testing.T) {
+	catalog, err := buildCatalog(testCharts(), nil, nil, "", offerAll, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+
+	if chart, ok := catalog.chart("redis"); !ok || chart != "redis" {
+		t.Errorf("catalog.chart(redis): expected the legacy service ID to be accepted, actual %q", chart)
+	}
+	tests := []struct {
+		serviceID string
+		planID    string
+	}{
+		{"redis", "redis-5-0-7"},
+		{"redis", makePlanID("redis", "5.0.7")},
+		{makeServiceID("redis"), "redis-5-0-7"},
+	}
+	for _, tt := range tests {
+		plan, ok := catalog.plan(tt.serviceID, tt.planID)
+		if !ok || plan.chartVersion.Version != "10.5.7" {
+			t.Errorf("catalog.plan(%s, %s): expected chart version 10.5.7, actual %v", tt.serviceID, tt.planID, plan.chartVersion)
+		}
+	}
+	if _, ok := catalog.plan("wordpress", "redis-5-0-7"); ok {
+		t.Errorf("catalog.plan(wordpress, redis-5-0-7): expected the legacy plan to belong to redis only")
+	}
+}
+
+func TestCatalogLegacyIDConflicts(t *testing.T) {
+	charts := map[string]repo.ChartVersions{
+		"redis":   {testChartVersion("redis", "10.0.0", "1.0")},
+		"redis-1": {testChartVersion("redis-1", "2.0.0", "0")},
+	}
+	catalog, err := buildCatalog(charts, nil, nil, "", offerAll, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+	if plan, ok := catalog.plan("redis", "redis-1-0"); !ok || plan.chartVersion.Version != "10.0.0" {
+		t.Errorf("catalog.plan(redis, redis-1-0): expected chart version 10.0.0, actual %v", plan.chartVersion)
+	}
+	if _, ok := catalog.plan("redis-1", "redis-1-0"); ok {
+		t.Errorf("catalog.plan(redis-1, redis-1-0): expected the legacy ID to be left to redis")
+	}
+	if _, ok := catalog.plan("redis-1", makePlanID("redis-1", "0")); !ok {
+		t.Errorf("catalog.plan(redis-1): expected the plan to be offered")
+	}
+
+	// Curated plans have no legacy IDs.
+	config, err := LoadCatalogConfig([]byte(`services: [{chart: redis, plans: [{name: foo, appVersion: "1.0"}, {name: FOO, appVersion: "1.0"}]}]`))
+	if err != nil {
+		t.Fatalf("LoadCatalogConfig: unexpected error: %v", err)
+	}
+	if catalog, err = buildCatalog(charts, config, nil, "", offerAll, bindAll); err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+	if _, ok := catalog.plan("redis", "redis-foo"); ok {
+		t.Errorf("catalog.plan(redis, redis-foo): expected no legacy ID for a curated plan")
+	}
+}
+
+func TestCatalogIDs(t *testing.T) {
+	if makeServiceID("redis") == makeServiceID("mariadb") {
+		t.Errorf("makeServiceID: expected different IDs for different charts")
+	}
+	if makePlanID("redis", "5.0.7") == makePlanID("redis", "5.0.8") {
+		t.Errorf("makePlanID: expected different IDs for different versions")
+	}
+}
+
+func TestBuildCuratedCatalog(t *testing.T) {
+	config, err := LoadCatalogConfig([]byte(`
+services:
+- chart: wordpress
+- chart: redis
+  name: cache
+  description: A Redis cache
+  tags: [cache, redis]
+  metadata:
+    displayName: Redis Cache
+  plans:
+  - name: stable
+    description: The stable Redis release
+    chartVersion: 10.5.6
+    metadata:
+      bullets: [persistent]
+  - name: legacy
+    appVersion: 4.0.14
+    free: false
+`))
+	if err != nil {
+		t.Fatalf("LoadCatalogConfig: unexpected error: %v", err)
+	}
+
+	catalog, err := buildCatalog(testCharts(), config, nil, "", func(string) bool { return false }, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+
+	if len(catalog.services) != 2 || catalog.services[0].Name != "wordpress" || catalog.services[1].Name != "cache" {
+		t.Fatalf("buildCatalog: expected the wordpress and redis services, actual %+v", catalog.services)
+	}
+	expectedIDs := []string{makePlanID("wordpress", "5.3.2")}
+	if ids := planIDs(catalog.services[:1]); !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("buildCatalog: expected the generated wordpress plans %v, actual %v", expectedIDs, ids)
+	}
+
+	redis := catalog.services[1]
+	expectedService := osb.Service{
+		ID:          makeServiceID("redis"),
+		Name:        "cache",
+		Description: "A Redis cache",
+		Bindable:    true,
+		Tags:        []string{"cache", "redis"},
+		Metadata: map[string]interface{}{
+			"displayName":     "Redis Cache",
+			"longDescription": "redis 10.5.7",
+		},
+		Plans: []osb.Plan{
+			{
+				ID:          makePlanID("redis", "stable"),
+				Name:        "stable",
+				Description: "The stable Redis release",
+				Free:        boolPtr(true),
+				Metadata: map[string]interface{}{
+					"displayName": "Cache 5.0.7",
+					"bullets":     []interface{}{"persistent"},
+				},
+			},
+			{
+				ID:          makePlanID("redis", "legacy"),
+				Name:        "legacy",
+				Description: "redis 9.0.0",
+				Free:        boolPtr(false),
+				Metadata: map[string]interface{}{
+					"displayName": "Cache 4.0.14",
+					"bullets":     []string{"Chart version 9.0.0", "App version 4.0.14"},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(redis, expectedService) {
+		t.Errorf("buildCatalog: expected service %+v, actual %+v", expectedService, redis)
+	}
+
+	if plan, ok := catalog.plan(redis.ID, redis.Plans[0].ID); !ok || plan.chartVersion.Version != "10.5.6" {
+		t.Errorf("catalog.plan(%s, %s): expected the pinned chart version 10.5.6, actual %v", redis.ID, redis.Plans[0].ID, plan.chartVersion)
+	}
+}
+
+func TestBuildCuratedCatalogErrors(t *testing.T) {
+	tests := []struct {
+		config   string
+		expected string
+	}{
+		{
+			`services: [{chart: mysql}]`,
+			`invalid catalog: chart "mysql" not found in the Helm repository`,
+		},
+		{
+			`services: [{name: foo}]`,
+			`invalid catalog: a chart is required for every service`,
+		},
+		{
+			`services: [{chart: redis}, {chart: redis}]`,
+			`invalid catalog: chart "redis" is listed more than once`,
+		},
+		{
+			`services: [{chart: redis, plans: [{name: foo, chartVersion: 1.0.0}]}]`,
+			`invalid catalog: plan "foo": chart "redis" has no version 1.0.0`,
+		},
+		{
+			`services: [{chart: redis, plans: [{name: foo, appVersion: 6.0.0}]}]`,
+			`invalid catalog: plan "foo": chart "redis" has no version with app version 6.0.0`,
+		},
+		{
+			`services: [{chart: redis, plans: [{name: foo, chartVersion: 9.0.0, appVersion: 5.0.7}]}]`,
+			`invalid catalog: plan "foo": chart "redis" has no version 9.0.0 with app version 5.0.7`,
+		},
+		{
+			`services: [{chart: redis, plans: [{name: foo}]}]`,
+			`invalid catalog: plan "foo" of chart "redis" must set an app version or a chart version`,
+		},
+		{
+			`services: [{chart: redis, plans: [{chartVersion: 9.0.0}]}]`,
+			`invalid catalog: a name is required for every plan of chart "redis"`,
+		},
+	}
+
+	for _, tt := range tests {
+		config, err := LoadCatalogConfig([]byte(tt.config))
+		if err != nil {
+			t.Errorf("LoadCatalogConfig(%q): unexpected error: %v", tt.config, err)
+			continue
+		}
+		_, err = buildCatalog(testCharts(), config, nil, "", offerAll, bindAll)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("buildCatalog(%q): expected error %q, actual %v", tt.config, tt.expected, err)
+		}
+	}
+}
+
+func TestBuildCatalogWithTiers(t *testing.T) {
+	tiers := map[string][]Tier{
+		"redis": {
+			{Name: "small", Description: "1 replica", Values: map[string]interface{}{"cluster": map[string]interface{}{"slaveCount": 1}}},
+			{Name: "large", Values: map[string]interface{}{"cluster": map[string]interface{}{"slaveCount": 3}}},
+		},
+	}
+	catalog, err := buildCatalog(testCharts(), nil, tiers, "", func(chart string) bool { return chart == "redis" }, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+
+	plans := catalog.services[0].Plans
+	var names []string
+	for _, plan := range plans {
+		names = append(names, plan.Name)
+	}
+	expectedNames := []string{"5-0-7-small", "5-0-7-large", "4-0-14-small", "4-0-14-large"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("buildCatalog: expected plans %v, actual %v", expectedNames, names)
+	}
+	if plans[0].Description != "redis 10.5.7 (1 replica)" || plans[1].Description != "redis 10.5.7" {
+		t.Errorf("buildCatalog: unexpected plan descriptions %q and %q", plans[0].Description, plans[1].Description)
+	}
+	if plans[0].ID == plans[1].ID || plans[0].ID == makePlanID("redis", "5.0.7") {
+		t.Errorf("buildCatalog: expected a distinct ID for each tier, actual %v", planIDs(catalog.services))
+	}
+
+	plan, ok := catalog.plan(catalog.services[0].ID, plans[1].ID)
+	if !ok || plan.chartVersion.Version != "10.5.7" || plan.tier == nil || plan.tier.Name != "large" {
+		t.Errorf("catalog.plan(%s): expected the large tier of chart version 10.5.7, actual %+v", plans[1].ID, plan)
+	}
+	if plan, ok := catalog.plan("redis", "redis-5-0-7"); !ok || plan.tier != nil {
+		t.Errorf("catalog.plan(redis, redis-5-0-7): expected the legacy plan without a tier, actual %+v", plan)
+	}
+}
+
+func TestBuildCatalogTierErrors(t *testing.T) {
+	tests := []struct {
+		tiers    []Tier
+		expected string
+	}{
+		{
+			[]Tier{{Name: "Small"}},
+			`invalid catalog: tier "Small" of chart "redis" must consist of lower case alphanumeric characters or '-'`,
+		},
+		{
+			[]Tier{{Name: "small"}, {Name: "small"}},
+			`invalid catalog: tier "small" of chart "redis" is defined more than once`,
+		},
+		{
+			[]Tier{{Name: "small"}, {Name: "large-small"}},
+			`invalid catalog: plan "latest-large-small" of chart "redis" is listed more than once`,
+		},
+	}
+
+	config, err := LoadCatalogConfig([]byte(`services: [{chart: redis, plans: [{name: latest-large, appVersion: 5.0.7}, {name: latest, appVersion: 4.0.14}]}]`))
+	if err != nil {
+		t.Fatalf("LoadCatalogConfig: unexpected error: %v", err)
+	}
+	for _, tt := range tests {
+		_, err := buildCatalog(testCharts(), config, map[string][]Tier{"redis": tt.tiers}, "", offerAll, bindAll)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("buildCatalog(%+v): expected error %q, actual %v", tt.tiers, tt.expected, err)
+		}
+	}
+}
+
+func TestCatalogMetadata(t *testing.T) {
+	charts := map[string]repo.ChartVersions{
+		"postgresql": {
+			testChartVersion("postgresql", "8.6.4", "11.7.0"),
+			testChartVersion("postgresql", "8.0.0", "11.6.0"),
+		},
+	}
+	latest := charts["postgresql"][0].Metadata
+	latest.Icon = "https://example.com/postgresql.png"
+	latest.Home = "https://www.postgresql.org"
+	latest.Maintainers = []*chart.Maintainer{{Name: "Bitnami"}, {Name: "desaintmartin"}}
+	charts["postgresql"][1].Deprecated = true
+	tiers := map[string][]Tier{"postgresql": {{Name: "small"}}}
+
+	catalog, err := buildCatalog(charts, nil, tiers, "", offerAll, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+
+	service := catalog.services[0]
+	expectedMetadata := map[string]interface{}{
+		"displayName":         "Postgresql",
+		"imageUrl":            "https://example.com/postgresql.png",
+		"documentationUrl":    "https://www.postgresql.org",
+		"providerDisplayName": "Bitnami, desaintmartin",
+		"longDescription":     "postgresql 8.6.4",
+	}
+	if !reflect.DeepEqual(service.Metadata, expectedMetadata) {
+		t.Errorf("buildCatalog: expected service metadata %v, actual %v", expectedMetadata, service.Metadata)
+	}
+	expectedMetadata = map[string]interface{}{
+		"displayName": "Postgresql 11.6.0 (small)",
+		"bullets":     []string{"Chart version 8.0.0", "App version 11.6.0", "Deprecated"},
+	}
+	if !reflect.DeepEqual(service.Plans[1].Metadata, expectedMetadata) {
+		t.Errorf("buildCatalog: expected plan metadata %v, actual %v", expectedMetadata, service.Plans[1].Metadata)
+	}
+}
+
+func TestCatalogMetadataTemplates(t *testing.T) {
+	config, err := LoadCatalogConfig([]byte(`
+metadataTemplates:
+  service:
+    displayName: "{{ .Name | upper }}"
+    longDescription: ""
+  plan:
+    displayName: "{{ .Name }}"
+  planBullets:
+  - "{{ .Chart.AppVersion }}"
+`))
+	if err != nil {
+		t.Fatalf("LoadCatalogConfig: unexpected error: %v", err)
+	}
+
+	catalog, err := buildCatalog(testCharts(), config, nil, "", func(chart string) bool { return chart == "wordpress" }, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+
+	service := catalog.services[0]
+	if expected := map[string]interface{}{"displayName": "WORDPRESS"}; !reflect.DeepEqual(service.Metadata, expected) {
+		t.Errorf("buildCatalog: expected service metadata %v, actual %v", expected, service.Metadata)
+	}
+	expected := map[string]interface{}{"displayName": "5-3-2", "bullets": []string{"5.3.2"}}
+	if !reflect.DeepEqual(service.Plans[0].Metadata, expected) {
+		t.Errorf("buildCatalog: expected plan metadata %v, actual %v", expected, service.Plans[0].Metadata)
+	}
+}
+
+func TestCatalogMetadataTemplateErrors(t *testing.T) {
+	tests := []struct {
+		config   string
+		expected string
+	}{
+		{
+			`metadataTemplates: {service: {displayName: "{{ .Name"}}`,
+			`invalid catalog: failed to parse the service displayName metadata template: `,
+		},
+		{
+			`metadataTemplates: {plan: {displayName: "{{ .Tier.Name }}"}}`,
+			`invalid catalog: plan "5-3-2": failed to render the plan displayName metadata template: `,
+		},
+	}
+
+	for _, tt := range tests {
+		config, err := LoadCatalogConfig([]byte(tt.config))
+		if err != nil {
+			t.Errorf("LoadCatalogConfig(%q): unexpected error: %v", tt.config, err)
+			continue
+		}
+		_, err = buildCatalog(testCharts(), config, nil, "", func(chart string) bool { return chart == "wordpress" }, bindAll)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("buildCatalog(%q): expected error starting with %q, actual %v", tt.config, tt.expected, err)
+		}
+	}
+}
+
+func TestCatalogLatestPlan(t *testing.T) {
+	tests := []struct {
+		config       string
+		expected     []string
+		chartVersion string
+	}{
+		{`latestPlan: true`, []string{"latest", "5-0-7", "4-0-14"}, "10.5.7"},
+		{`{latestPlan: true, services: [{chart: redis, latestPlan: false}]}`, []string{"5-0-7", "4-0-14"}, ""},
+		{`{latestPlan: true, filter: {appVersions: "< 5"}}`, []string{"latest", "4-0-14"}, "9.0.0"},
+		{`services: [{chart: redis, latestPlan: true, plans: [{name: pinned, chartVersion: 9.0.0}]}]`, []string{"latest", "pinned"}, "10.5.7"},
+	}
+
+	for _, tt := range tests {
+		config, err := LoadCatalogConfig([]byte(tt.config))
+		if err != nil {
+			t.Errorf("LoadCatalogConfig(%q): unexpected error: %v", tt.config, err)
+			continue
+		}
+		catalog, err := buildCatalog(testCharts(), config, nil, "", func(chart string) bool { return chart == "redis" }, bindAll)
+		if err != nil {
+			t.Errorf("buildCatalog(%q): unexpected error: %v", tt.config, err)
+			continue
+		}
+		service := catalog.services[0]
+		if actual := servicePlanNames(catalog)[service.Name]; !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("buildCatalog(%q): expected the plans %v, actual %v", tt.config, tt.expected, actual)
+		}
+		if tt.chartVersion == "" {
+			continue
+		}
+		plan, ok := catalog.plan(service.ID, makePlanID("redis", "latest"))
+		if !ok || !plan.latest || plan.chartVersion.Version != tt.chartVersion {
+			t.Errorf("buildCatalog(%q): expected the latest plan to be backed by %s, actual %+v", tt.config, tt.chartVersion, plan)
+		}
+		if _, ok := catalog.plan(service.ID, "redis-latest"); ok {
+			t.Errorf("buildCatalog(%q): expected the latest plan to have no legacy ID", tt.config)
+		}
+	}
+}
+
+func TestCatalogLatestPlanMetadata(t *testing.T) {
+	catalog, err := buildCatalog(testCharts(), &CatalogConfig{LatestPlan: true}, nil, "", func(chart string) bool { return chart == "redis" }, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+	latest := catalog.services[0].Plans[0]
+	if latest.Description != "The latest version of redis, currently 5.0.7" || latest.Metadata["displayName"] != "Redis latest, currently 5.0.7" {
+		t.Errorf("buildCatalog: unexpected latest plan %+v", latest)
+	}
+}
+
+func TestCatalogLatestPlanConflict(t *testing.T) {
+	config, err := LoadCatalogConfig([]byte(`services: [{chart: redis, latestPlan: true, plans: [{name: latest, appVersion: 4.0.14}]}]`))
+	if err != nil {
+		t.Fatalf("LoadCatalogConfig: unexpected error: %v", err)
+	}
+	_, err = buildCatalog(testCharts(), config, nil, "", offerAll, bindAll)
+	expected := `invalid catalog: plan "latest" of chart "redis" is listed more than once`
+	if err == nil || err.Error() != expected {
+		t.Errorf("buildCatalog: expected error %q, actual %v", expected, err)
+	}
+}
+
+func TestLoadCatalogConfigUnknownField(t *testing.T) {
+	_, err := LoadCatalogConfig([]byte(`services: [{chart: redis, displayName: Redis}]`))
+	if err == nil || !strings.Contains(err.Error(), "displayName") {
+		t.Errorf("LoadCatalogConfig: expected an unknown field error, actual %v", err)
+	}
+}
+
+// syntheticCharts returns an index of charts with versions each, spread over a few app versions.
+func syntheticCharts(charts, versions int) map[string]repo.ChartVersions {
+	index := make(map[string]repo.ChartVersions, charts)
+	for i := 0; i < charts; i++ {
+		name := fmt.Sprintf("chart-%d", i)
+		chartVersions := make(repo.ChartVersions, versions)
+		for j := range chartVersions {
+			chartVersions[j] = testChartVersion(name, fmt.Sprintf("%d.%d.0", versions-j, j%3), fmt.Sprintf("%d.0.%d", (versions-j)/10, j%10))
+		}
+		index[name] = chartVersions
+	}
+	return index
+}
+
+func BenchmarkBuildCatalog(b *testing.B) {
+	charts := syntheticCharts(500, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := buildCatalog(charts, nil, nil, "", offerAll, bindAll); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCatalogBindable(t *testing.T) {
+	tests := []struct {
+		config   string
+		expected map[string]bool
+	}{
+		{``, map[string]bool{"redis": true, "wordpress": false}},
+		{`services: [{chart: redis}, {chart: wordpress}]`, map[string]bool{"redis": true, "wordpress": false}},
+		{`services: [{chart: redis, bindable: false}, {chart: wordpress, bindable: true}]`, map[string]bool{"redis": false, "wordpress": true}},
+	}
+
+	for _, tt := range tests {
+		config, err := LoadCatalogConfig([]byte(tt.config))
+		if err != nil {
+			t.Errorf("LoadCatalogConfig(%q): unexpected error: %v", tt.config, err)
+			continue
+		}
+		catalog, err := buildCatalog(testCharts(), config, nil, "", offerAll, func(chart string) bool { return chart == "redis" })
+		if err != nil {
+			t.Errorf("buildCatalog(%q): unexpected error: %v", tt.config, err)
+			continue
+		}
+		actual := make(map[string]bool)
+		for _, service := range catalog.services {
+			actual[service.Name] = service.Bindable
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("buildCatalog(%q): expected the bindable services %v, actual %v", tt.config, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// CatalogFilter selects the charts listed as services and the chart versions backing their
+// generated plans. Plans listed in a curated catalog are not filtered. The version constraints
+// follow the syntax of the Helm version ranges.
+type CatalogFilter struct {
+	// A semver constraint on the chart versions, e.g. ">= 8.0.0".
+	ChartVersions string `json:"chartVersions,omitempty"`
+	// A semver constraint on the app versions, e.g. "~11". Non-semver app versions don't match.
+	AppVersions string `json:"appVersions,omitempty"`
+	// The maximum number of generated plans. The first plans in the index order, i.e. backed by the
+	// latest chart versions, are kept.
+	MaxPlans int `json:"maxPlans,omitempty"`
+	// Only lists the charts with any of these keywords.
+	IncludeKeywords []string `json:"includeKeywords,omitempty"`
+	// Hides the charts with any of these keywords.
+	ExcludeKeywords []string `json:"excludeKeywords,omitempty"`
+	// Hides the deprecated chart versions.
+	HideDeprecated bool `json:"hideDeprecated,omitempty"`
+	// Hides the chart versions whose kubeVersion constraint doesn't match the cluster version.
+	MatchKubeVersion bool `json:"matchKubeVersion,omitempty"`
+}
+
+// matchesKubeVersion reports whether any filter of the catalog matches the cluster version.
+func (c *CatalogConfig) matchesKubeVersion() bool {
+	if c == nil {
+		return false
+	}
+	if c.Filter != nil && c.Filter.MatchKubeVersion {
+		return true
+	}
+	for _, service := range c.Services {
+		if service.Filter != nil && service.Filter.MatchKubeVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// chartFilter is a compiled CatalogFilter. A nil chartFilter accepts everything.
+type chartFilter struct {
+	config        CatalogFilter
+	chartVersions *semver.Constraints
+	appVersions   *semver.Constraints
+	kubeVersion   string
+}
+
+// newChartFilter compiles a filter. kubeVersion is the version of the cluster; when empty, the
+// kubeVersion constraints of the charts are not checked.
+func newChartFilter(config *CatalogFilter, kubeVersion string) (*chartFilter, error) {
+	if config == nil {
+		return nil, nil
+	}
+	f := &chartFilter{config: *config, kubeVersion: kubeVersion}
+	var err error
+	if config.ChartVersions != "" {
+		if f.chartVersions, err = semver.NewConstraint(config.ChartVersions); err != nil {
+			return nil, fmt.Errorf("invalid chart versions constraint %q: %v", config.ChartVersions, err)
+		}
+	}
+	if config.AppVersions != "" {
+		if f.appVersions, err = semver.NewConstraint(config.AppVersions); err != nil {
+			return nil, fmt.Errorf("invalid app versions constraint %q: %v", config.AppVersions, err)
+		}
+	}
+	if config.MaxPlans < 0 {
+		return nil, fmt.Errorf("invalid maximum number of plans %d", config.MaxPlans)
+	}
+	return f, nil
+}
+
+// acceptsChart checks the keywords of the latest version of a chart.
+func (f *chartFilter) acceptsChart(latest *repo.ChartVersion) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.config.IncludeKeywords) > 0 && !hasAnyKeyword(latest, f.config.IncludeKeywords) {
+		return false
+	}
+	return !hasAnyKeyword(latest, f.config.ExcludeKeywords)
+}
+
+// filterChartVersions returns the chart versions accepted by the filter.
+func (f *chartFilter) filterChartVersions(chartVersions repo.ChartVersions) repo.ChartVersions {
+	if f == nil {
+		return chartVersions
+	}
+	var filtered repo.ChartVersions
+	for _, chartVersion := range chartVersions {
+		if f.acceptsChartVersion(chartVersion) {
+			filtered = append(filtered, chartVersion)
+		}
+	}
+	return filtered
+}
+
+func (f *chartFilter) acceptsChartVersion(chartVersion *repo.ChartVersion) bool {
+	if f.config.HideDeprecated && chartVersion.Deprecated {
+		return false
+	}
+	if f.chartVersions != nil && !checkConstraint(f.chartVersions, chartVersion.Version) {
+		return false
+	}
+	if f.appVersions != nil && !checkConstraint(f.appVersions, chartVersion.AppVersion) {
+		return false
+	}
+	if f.config.MatchKubeVersion && f.kubeVersion != "" && chartVersion.KubeVersion != "" {
+		// The check Helm runs when installing the chart.
+		if !chartutil.IsCompatibleRange(chartVersion.KubeVersion, f.kubeVersion) {
+			return false
+		}
+	}
+	return true
+}
+
+// limitPlans keeps the maximum number of plans.
+func (f *chartFilter) limitPlans(plans []CatalogPlanConfig) []CatalogPlanConfig {
+	if f == nil || f.config.MaxPlans == 0 || len(plans) <= f.config.MaxPlans {
+		return plans
+	}
+	return plans[:f.config.MaxPlans]
+}
+
+func checkConstraint(constraint *semver.Constraints, version string) bool {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	return constraint.Check(v)
+}
+
+func hasAnyKeyword(chartVersion *repo.ChartVersion, keywords []string) bool {
+	for _, keyword := range keywords {
+		if hasTag(keyword, chartVersion.Keywords) {
+			return true
+		}
+	}
+	return false
+}
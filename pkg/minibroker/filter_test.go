@@ -0,0 +1,180 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"reflect"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func testFilterCharts() map[string]repo.ChartVersions {
+	latest := testChartVersion("postgresql", "9.1.0", "11.9.0")
+	latest.KubeVersion = ">=1.19.0"
+	deprecated := testChartVersion("postgresql", "8.0.0", "11.6.0")
+	deprecated.Deprecated = true
+	charts := map[string]repo.ChartVersions{
+		"postgresql": {
+			latest,
+			testChartVersion("postgresql", "8.6.4", "11.7.0"),
+			deprecated,
+			testChartVersion("postgresql", "7.0.0", "10.10.0"),
+		},
+		"redis": {
+			testChartVersion("redis", "10.5.7", "5.0.7"),
+		},
+	}
+	for _, chartVersion := range charts["postgresql"] {
+		chartVersion.Keywords = []string{"postgresql", "database"}
+	}
+	charts["redis"][0].Keywords = []string{"redis", "cache"}
+	return charts
+}
+
+func servicePlanNames(c *catalog) map[string][]string {
+	names := make(map[string][]string)
+	for _, service := range c.services {
+		names[service.Name] = []string{}
+		for _, plan := range service.Plans {
+			names[service.Name] = append(names[service.Name], plan.Name)
+		}
+	}
+	return names
+}
+
+func TestCatalogFilter(t *testing.T) {
+	tests := []struct {
+		config      string
+		kubeVersion string
+		expected    map[string][]string
+	}{
+		{
+			`filter: {appVersions: "~11", hideDeprecated: true, matchKubeVersion: true}`,
+			"v1.18.2",
+			map[string][]string{"postgresql": {"11-7-0"}, "redis": {}},
+		},
+		{
+			`filter: {matchKubeVersion: true}`,
+			"",
+			map[string][]string{"postgresql": {"11-9-0", "11-7-0", "11-6-0", "10-10-0"}, "redis": {"5-0-7"}},
+		},
+		{
+			`filter: {chartVersions: ">= 8.0.0", maxPlans: 2}`,
+			"",
+			map[string][]string{"postgresql": {"11-9-0", "11-7-0"}, "redis": {"5-0-7"}},
+		},
+		{
+			`filter: {includeKeywords: [database, storage]}`,
+			"",
+			map[string][]string{"postgresql": {"11-9-0", "11-7-0", "11-6-0", "10-10-0"}},
+		},
+		{
+			`filter: {excludeKeywords: [database]}`,
+			"",
+			map[string][]string{"redis": {"5-0-7"}},
+		},
+		{
+			`
+filter: {maxPlans: 1}
+services:
+- chart: postgresql
+  filter: {appVersions: "< 11"}
+- chart: redis
+`,
+			"",
+			map[string][]string{"postgresql": {"10-10-0"}, "redis": {"5-0-7"}},
+		},
+		{
+			`
+services:
+- chart: postgresql
+  filter: {appVersions: "> 12"}
+- chart: redis
+  filter: {excludeKeywords: [cache]}
+  plans: [{name: stable, appVersion: 5.0.7}]
+`,
+			"",
+			map[string][]string{},
+		},
+		{
+			`
+services:
+- chart: postgresql
+  filter: {appVersions: "< 11"}
+  plans: [{name: latest, chartVersion: 9.1.0}]
+`,
+			"",
+			map[string][]string{"postgresql": {"latest"}},
+		},
+	}
+
+	for _, tt := range tests {
+		config, err := LoadCatalogConfig([]byte(tt.config))
+		if err != nil {
+			t.Errorf("LoadCatalogConfig(%q): unexpected error: %v", tt.config, err)
+			continue
+		}
+		catalog, err := buildCatalog(testFilterCharts(), config, nil, tt.kubeVersion, offerAll, bindAll)
+		if err != nil {
+			t.Errorf("buildCatalog(%q): unexpected error: %v", tt.config, err)
+			continue
+		}
+		expected := tt.expected
+		for service := range expected {
+			if len(expected[service]) == 0 {
+				// Services without plans are not listed.
+				delete(expected, service)
+			}
+		}
+		if actual := servicePlanNames(catalog); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("buildCatalog(%q): expected the plans %v, actual %v", tt.config, expected, actual)
+		}
+	}
+}
+
+func TestCatalogFilterErrors(t *testing.T) {
+	tests := []struct {
+		config   string
+		expected string
+	}{
+		{
+			`filter: {chartVersions: "not a constraint"}`,
+			`invalid catalog: invalid chart versions constraint "not a constraint": improper constraint: not a constraint`,
+		},
+		{
+			`filter: {maxPlans: -1}`,
+			`invalid catalog: invalid maximum number of plans -1`,
+		},
+		{
+			`services: [{chart: redis, filter: {appVersions: "~>"}}]`,
+			`invalid catalog: chart "redis": invalid app versions constraint "~>": improper constraint: ~>`,
+		},
+	}
+
+	for _, tt := range tests {
+		config, err := LoadCatalogConfig([]byte(tt.config))
+		if err != nil {
+			t.Errorf("LoadCatalogConfig(%q): unexpected error: %v", tt.config, err)
+			continue
+		}
+		_, err = buildCatalog(testFilterCharts(), config, nil, "", offerAll, bindAll)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("buildCatalog(%q): expected error %q, actual %v", tt.config, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// The binding parameters requesting extra representations of the credentials.
+const (
+	credentialFormatsParam = "credentialFormats"
+	envPrefixParam         = "envPrefix"
+
+	jdbcFormat       = "jdbc"
+	envFormat        = "env"
+	propertiesFormat = "properties"
+)
+
+// The credentials holding the extra representations.
+const (
+	jdbcURLCredential    = "jdbcUrl"
+	envCredential        = "env"
+	propertiesCredential = "properties"
+)
+
+var envPrefixPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// JDBCFormatter is implemented by the Providers of services with a JDBC driver.
+type JDBCFormatter interface {
+	// JDBCURL returns the JDBC URL of the credentials, with the user and password.
+	JDBCURL(creds Object) (string, error)
+}
+
+// PropertiesFormatter is implemented by the Providers of services configured by Spring Boot.
+type PropertiesFormatter interface {
+	// Properties returns the Spring Boot properties of the credentials.
+	Properties(creds Object) (Object, error)
+}
+
+// credentialFormats returns the formats requested by the binding parameters.
+func credentialFormats(bindParams *BindParams) []string {
+	value, _ := bindParams.Dig(credentialFormatsParam)
+	list, _ := value.([]interface{})
+	formats := make([]string, 0, len(list))
+	for _, format := range list {
+		if s, ok := format.(string); ok {
+			formats = append(formats, s)
+		}
+	}
+	return formats
+}
+
+// validateCredentialFormatParams checks the formats requested by the binding parameters are known
+// and produced by the provider.
+func validateCredentialFormatParams(provider Provider, bindParams *BindParams) []FieldError {
+	var fieldErrors []FieldError
+	requested := map[string]bool{}
+	if value, ok := bindParams.Dig(credentialFormatsParam); ok {
+		list, isList := value.([]interface{})
+		if !isList {
+			return []FieldError{{Field: credentialFormatsParam, Description: "must be a list"}}
+		}
+		for i, format := range list {
+			field := fmt.Sprintf("%s[%d]", credentialFormatsParam, i)
+			switch format {
+			case envFormat:
+			case jdbcFormat:
+				if _, ok := provider.(JDBCFormatter); !ok {
+					fieldErrors = append(fieldErrors, FieldError{Field: field, Description: "jdbc is not supported by the service"})
+				}
+			case propertiesFormat:
+				if _, ok := provider.(PropertiesFormatter); !ok {
+					fieldErrors = append(fieldErrors, FieldError{Field: field, Description: "properties are not supported by the service"})
+				}
+			default:
+				fieldErrors = append(fieldErrors, FieldError{Field: field, Description: fmt.Sprintf("must be %s, %s or %s", jdbcFormat, envFormat, propertiesFormat)})
+				continue
+			}
+			requested[format.(string)] = true
+		}
+	}
+	if prefix, ok := bindParams.Dig(envPrefixParam); ok {
+		if s, isString := prefix.(string); !isString || !envPrefixPattern.MatchString(s) {
+			fieldErrors = append(fieldErrors, FieldError{Field: envPrefixParam, Description: "must be a valid environment variable name"})
+		} else if !requested[envFormat] {
+			fieldErrors = append(fieldErrors, FieldError{Field: envPrefixParam, Description: fmt.Sprintf("requires the %s format", envFormat)})
+		}
+	}
+	return fieldErrors
+}
+
+// formatCredentials adds the representations of the credentials requested by the binding
+// parameters. The environment variables are prefixed with envPrefix, defaulting to the chart name.
+func formatCredentials(chart string, provider Provider, bindParams *BindParams, creds Object) (Object, error) {
+	formats := credentialFormats(bindParams)
+	if len(formats) == 0 {
+		return creds, nil
+	}
+	requested := map[string]bool{}
+	for _, format := range formats {
+		requested[format] = true
+	}
+
+	formatted := make(Object, len(creds)+len(formats))
+	for k, v := range creds {
+		formatted[k] = v
+	}
+	if requested[jdbcFormat] {
+		jdbcURL, err := provider.(JDBCFormatter).JDBCURL(creds)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build the JDBC URL: %v", err)
+		}
+		formatted[jdbcURLCredential] = jdbcURL
+	}
+	if requested[envFormat] {
+		defaultPrefix := strings.ToUpper(strings.ReplaceAll(chart, "-", "_"))
+		prefix, err := bindParams.DigStringOr(envPrefixParam, defaultPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the environment variable prefix: %w", err)
+		}
+		env := Object{}
+		flattenEnv(env, prefix, formatted)
+		formatted[envCredential] = env
+	}
+	if requested[propertiesFormat] {
+		properties, err := provider.(PropertiesFormatter).Properties(creds)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build the properties: %v", err)
+		}
+		formatted[propertiesCredential] = properties
+	}
+	return formatted, nil
+}
+
+// flattenEnv adds the environment variables of the credentials under prefix, e.g. DATABASE_URL for
+// the uri credential and RABBITMQ_PORTS_AMQP for the amqp port. Lists are joined with commas.
+func flattenEnv(env Object, prefix string, value interface{}) {
+	switch v := value.(type) {
+	case Object:
+		flattenEnv(env, prefix, map[string]interface{}(v))
+	case map[string]interface{}:
+		for key, nested := range v {
+			flattenEnv(env, prefix+"_"+envName(key), nested)
+		}
+	case []string:
+		env[prefix] = strings.Join(v, ",")
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		env[prefix] = strings.Join(items, ",")
+	case nil:
+	default:
+		env[prefix] = fmt.Sprint(v)
+	}
+}
+
+// envName returns the environment variable name of a credential, e.g. JDBC_URL for jdbcUrl. URIs are
+// named URLs, as twelve-factor apps expect, e.g. DATABASE_URL.
+func envName(key string) string {
+	var name strings.Builder
+	previous := rune(0)
+	for _, r := range key {
+		switch {
+		case unicode.IsUpper(r) && unicode.IsLower(previous):
+			name.WriteRune('_')
+			name.WriteRune(r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			name.WriteRune(unicode.ToUpper(r))
+		default:
+			name.WriteRune('_')
+		}
+		previous = r
+	}
+	words := strings.Split(name.String(), "_")
+	for i, word := range words {
+		if word == "URI" {
+			words[i] = "URL"
+		}
+	}
+	return strings.Join(words, "_")
+}
+
+// jdbcURL returns the JDBC URL of SQL credentials. On instances served over TLS, sslParam is set to
+// verifyMode when the CA certificate is known, and requireMode otherwise.
+func jdbcURL(subprotocol string, creds Object, sslParam, verifyMode, requireMode string) (string, error) {
+	host, err := creds.DigString("host")
+	if err != nil {
+		return "", fmt.Errorf("failed to get host: %w", err)
+	}
+	username, err := creds.DigString("username")
+	if err != nil {
+		return "", fmt.Errorf("failed to get username: %w", err)
+	}
+	password, err := creds.DigString("password")
+	if err != nil {
+		return "", fmt.Errorf("failed to get password: %w", err)
+	}
+	database, _ := creds.DigStringOr("database", "")
+	query := url.Values{"user": {username}, "password": {password}}
+	if creds[tlsCredential] == true {
+		if _, ok := creds[caCertCredential]; ok {
+			query.Set(sslParam, verifyMode)
+		} else {
+			query.Set(sslParam, requireMode)
+		}
+	}
+	return fmt.Sprintf("jdbc:%s://%s:%v/%s?%s", subprotocol, host, creds["port"], url.PathEscape(database), query.Encode()), nil
+}
+
+// datasourceProperties returns the Spring Boot properties of a SQL data source.
+func datasourceProperties(jdbcURL string, creds Object) Object {
+	return Object{
+		"spring.datasource.url":      jdbcURL,
+		"spring.datasource.username": creds["username"],
+		"spring.datasource.password": creds["password"],
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The credentials set by the MappingProvider itself.
+var mappedCredentials = map[string]struct{}{
+	"host":     {},
+	"port":     {},
+	"protocol": {},
+	"uri":      {},
+}
+
+// CredentialMapping declares how the credentials of a chart are built from its services, the
+// binding and provisioning parameters and its secrets, so that charts can be bound without a
+// dedicated Provider.
+type CredentialMapping struct {
+	// Selects the service and port clients connect to, setting the host and port credentials.
+	Service ServiceSelector `json:"service,omitempty"`
+	// The protocol credential, e.g. mysql.
+	Protocol string `json:"protocol,omitempty"`
+	// The sources of each credential, tried in order until one is found.
+	Credentials map[string][]CredentialSource `json:"credentials,omitempty"`
+	// The template of the uri credential, rendered with the other credentials, e.g.
+	// "mysql://{{ .username }}:{{ .password | urlquery }}@{{ .host }}:{{ .port }}/{{ .database }}".
+	URI string `json:"uri,omitempty"`
+
+	uri *template.Template
+}
+
+// ServiceSelector selects a service of a chart and its port. The first matching service and port
+// are selected.
+type ServiceSelector struct {
+	// The labels of the service.
+	Labels map[string]string `json:"labels,omitempty"`
+	// The labels selecting the pods of the service, e.g. role: master.
+	Selector map[string]string `json:"selector,omitempty"`
+	// The name of the port. The first port of the service is selected when empty.
+	Port string `json:"port,omitempty"`
+}
+
+// CredentialSource is a source of a credential. Exactly one of its fields is set.
+type CredentialSource struct {
+	// The path of a provisioning parameter, e.g. db.user.
+	Param string `json:"param,omitempty"`
+	// The path of a binding parameter.
+	BindParam string `json:"bindParam,omitempty"`
+	// The key of a secret of the chart.
+	Secret string `json:"secret,omitempty"`
+	// A literal value.
+	Value *string `json:"value,omitempty"`
+}
+
+// LoadCredentialMappings loads the credential mappings, by chart name, from YAML or JSON data.
+func LoadCredentialMappings(data []byte) (map[string]*CredentialMapping, error) {
+	var mappings map[string]*CredentialMapping
+	if err := yaml.Unmarshal(data, &mappings, yaml.DisallowUnknownFields); err != nil {
+		return nil, fmt.Errorf("failed to load credential mappings: %v", err)
+	}
+	charts := make([]string, 0, len(mappings))
+	for chart := range mappings {
+		charts = append(charts, chart)
+	}
+	sort.Strings(charts)
+	for _, chart := range charts {
+		if mappings[chart] == nil {
+			mappings[chart] = &CredentialMapping{}
+		}
+		if err := mappings[chart].compile(); err != nil {
+			return nil, fmt.Errorf("failed to load credential mappings: chart %q: %v", chart, err)
+		}
+	}
+	return mappings, nil
+}
+
+// compile validates the mapping and parses its URI template.
+func (m *CredentialMapping) compile() error {
+	for name, sources := range m.Credentials {
+		if _, ok := mappedCredentials[name]; ok {
+			return fmt.Errorf("credential %q is set by minibroker", name)
+		}
+		if len(sources) == 0 {
+			return fmt.Errorf("credential %q has no sources", name)
+		}
+		for i, source := range sources {
+			if source.count() != 1 {
+				return fmt.Errorf("source %d of credential %q must set exactly one of param, bindParam, secret or value", i, name)
+			}
+		}
+	}
+	if m.URI != "" {
+		uri, err := template.New("uri").Funcs(sprig.TxtFuncMap()).Option("missingkey=error").Parse(m.URI)
+		if err != nil {
+			return fmt.Errorf("invalid uri template: %v", err)
+		}
+		m.uri = uri
+	}
+	return nil
+}
+
+// count returns the number of fields set.
+func (s CredentialSource) count() int {
+	count := 0
+	for _, set := range []bool{s.Param != "", s.BindParam != "", s.Secret != "", s.Value != nil} {
+		if set {
+			count++
+		}
+	}
+	return count
+}
+
+// resolve returns the value of the source, if found.
+func (s CredentialSource) resolve(bindParams *BindParams, provisionParams *ProvisionParams, chartSecrets Object) (interface{}, bool) {
+	switch {
+	case s.Param != "" && provisionParams != nil:
+		return provisionParams.Dig(s.Param)
+	case s.BindParam != "" && bindParams != nil:
+		return bindParams.Dig(s.BindParam)
+	case s.Secret != "":
+		// Secret keys often contain dots, e.g. tls.crt, so they are not dug.
+		value, ok := chartSecrets[s.Secret]
+		return value, ok
+	case s.Value != nil:
+		return *s.Value, true
+	}
+	return nil, false
+}
+
+// selectService returns the first service matching the selector.
+func (s ServiceSelector) selectService(services []corev1.Service) (*corev1.Service, error) {
+	for i := range services {
+		if hasLabels(services[i].Labels, s.Labels) && hasLabels(services[i].Spec.Selector, s.Selector) {
+			return &services[i], nil
+		}
+	}
+	return nil, errors.New("could not identify the service")
+}
+
+// selectPort returns the port of the service matching the selector.
+func (s ServiceSelector) selectPort(service *corev1.Service) (*corev1.ServicePort, error) {
+	if len(service.Spec.Ports) == 0 {
+		return nil, errors.Errorf("no ports found")
+	}
+	if s.Port == "" {
+		return &service.Spec.Ports[0], nil
+	}
+	for i := range service.Spec.Ports {
+		if service.Spec.Ports[i].Name == s.Port {
+			return &service.Spec.Ports[i], nil
+		}
+	}
+	return nil, errors.Errorf("port %q not found in service %q", s.Port, service.Name)
+}
+
+// hasLabels returns whether labels include every label of subset.
+func hasLabels(labels, subset map[string]string) bool {
+	for key, value := range subset {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// MappingProvider is the Provider building the credentials declared by a CredentialMapping.
+type MappingProvider struct {
+	hostBuilder
+	mapping *CredentialMapping
+}
+
+func (p MappingProvider) Bind(
+	services []corev1.Service,
+	bindParams *BindParams,
+	provisionParams *ProvisionParams,
+	chartSecrets Object,
+) (Object, error) {
+	service, err := p.mapping.Service.selectService(services)
+	if err != nil {
+		return nil, err
+	}
+	svcPort, err := p.mapping.Service.selectPort(service)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := Object{
+		"host": p.hostFromService(service),
+		"port": svcPort.Port,
+	}
+	if p.mapping.Protocol != "" {
+		creds["protocol"] = p.mapping.Protocol
+	}
+	for name, sources := range p.mapping.Credentials {
+		found := false
+		for _, source := range sources {
+			if value, ok := source.resolve(bindParams, provisionParams, chartSecrets); ok {
+				creds[name] = value
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("failed to get %s: %w", name, ErrDigNotFound)
+		}
+	}
+	if p.mapping.uri != nil {
+		var buf bytes.Buffer
+		if err := p.mapping.uri.Execute(&buf, map[string]interface{}(creds)); err != nil {
+			return nil, fmt.Errorf("failed to render the uri: %v", err)
+		}
+		creds["uri"] = buf.String()
+	}
+
+	return creds, nil
+}
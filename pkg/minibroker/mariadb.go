@@ -20,7 +20,6 @@ import (
 	"fmt"
 	"net/url"
 
-	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 )
 
@@ -29,6 +28,13 @@ const (
 	rootMariadbUsername = "root"
 )
 
+// mariadbTLS are the TLS parameters of the mariadb chart, across chart versions.
+var mariadbTLS = tlsParams{
+	enabled:        []string{"tls.enabled"},
+	existingSecret: []string{"tls.existingSecret", "tls.certificatesSecret"},
+	caCert:         bitnamiTLSCaCert,
+}
+
 type MariadbProvider struct {
 	hostBuilder
 }
@@ -39,13 +45,13 @@ func (p MariadbProvider) Bind(
 	provisionParams *ProvisionParams,
 	chartSecrets Object,
 ) (Object, error) {
-	service := services[0]
-	if len(service.Spec.Ports) == 0 {
-		return nil, errors.Errorf("no ports found")
+	service, replica, err := primaryAndReplicaServices(services)
+	if err != nil {
+		return nil, err
 	}
 	svcPort := service.Spec.Ports[0]
 
-	host := p.hostFromService(&service)
+	host := p.hostFromService(service)
 
 	database, err := provisionParams.DigStringOr("db.name", "")
 	if err != nil {
@@ -67,6 +73,12 @@ func (p MariadbProvider) Bind(
 		return nil, fmt.Errorf("failed to get password: %w", err)
 	}
 
+	uri := &url.URL{
+		Scheme: mariadbProtocolName,
+		User:   url.UserPassword(user, password),
+		Host:   fmt.Sprintf("%s:%d", host, svcPort.Port),
+		Path:   database,
+	}
 	creds := Object{
 		"protocol": mariadbProtocolName,
 		"port":     svcPort.Port,
@@ -74,13 +86,60 @@ func (p MariadbProvider) Bind(
 		"username": user,
 		"password": password,
 		"database": database,
-		"uri": (&url.URL{
-			Scheme: mariadbProtocolName,
-			User:   url.UserPassword(user, password),
-			Host:   fmt.Sprintf("%s:%d", host, svcPort.Port),
-			Path:   database,
-		}).String(),
+	}
+	tlsEnabled, verify, err := mariadbTLS.addCredentials(creds, provisionParams, chartSecrets)
+	if err != nil {
+		return nil, err
+	}
+	if tlsEnabled {
+		uri.RawQuery = mysqlSSLMode(verify)
+	}
+	creds["uri"] = uri.String()
+	if replica != nil {
+		if err := addReadEndpoint(creds, p.hostFromService(replica), replica.Spec.Ports[0].Port); err != nil {
+			return nil, err
+		}
 	}
 
 	return creds, nil
 }
+
+// ValidateProvisionParams checks the parameters read when binding.
+func (p MariadbProvider) ValidateProvisionParams(provisionParams *ProvisionParams) []FieldError {
+	return validateStringParams(provisionParams, "db.name", "db.user")
+}
+
+// ReferencedSecrets returns the existing secret holding the TLS certificates, if any.
+func (p MariadbProvider) ReferencedSecrets(provisionParams *ProvisionParams) []string {
+	return mariadbTLS.referencedSecrets(provisionParams)
+}
+
+// JDBCURL returns the JDBC URL of the credentials, for MariaDB Connector/J.
+func (p MariadbProvider) JDBCURL(creds Object) (string, error) {
+	return jdbcURL("mariadb", creds, "sslMode", "verify-full", "trust")
+}
+
+// Properties returns the Spring Boot data source properties of the credentials.
+func (p MariadbProvider) Properties(creds Object) (Object, error) {
+	jdbc, err := p.JDBCURL(creds)
+	if err != nil {
+		return nil, err
+	}
+	return datasourceProperties(jdbc, creds), nil
+}
+
+// ValidateBindParams checks the parameters requesting a dedicated user.
+func (p MariadbProvider) ValidateBindParams(bindParams *BindParams) []FieldError {
+	return validateDedicatedUserParams(bindParams)
+}
+
+// OnBind creates the dedicated user requested by the binding parameters, if any, with privileges on
+// the database.
+func (p MariadbProvider) OnBind(binding *Binding, creds Object) (Object, error) {
+	return bindMySQLUser(binding, creds, rootMariadbUsername, "mariadb-root-password")
+}
+
+// OnUnbind drops the dedicated user of the binding, if any.
+func (p MariadbProvider) OnUnbind(binding *Binding, creds Object) error {
+	return unbindMySQLUser(binding, creds, rootMariadbUsername, "mariadb-root-password")
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// MetadataTemplates render the OSB metadata of the catalog entries from the Chart.yaml of the
+// charts backing them. Templates use the text/template syntax with the Sprig functions, as Helm
+// charts do. Services are rendered with .Name, the service name, and .Chart, the metadata of the
+// latest chart version. Plans are rendered with .Service, .Name, .Chart, the metadata of the chart
+// version backing the plan, .Tier, the tier sizing the plan if any, and .Latest, set for the latest
+// plan.
+type MetadataTemplates struct {
+	// The service metadata fields, e.g. displayName or imageUrl, replacing the default templates.
+	// A field with an empty template is not set.
+	Service map[string]string `json:"service,omitempty"`
+	// The plan metadata fields, replacing the default templates. A field with an empty template is
+	// not set.
+	Plan map[string]string `json:"plan,omitempty"`
+	// The plan bullets, replacing the default ones. Bullets rendering to an empty string are
+	// dropped.
+	PlanBullets []string `json:"planBullets,omitempty"`
+}
+
+var defaultServiceMetadataTemplates = map[string]string{
+	"displayName":         `{{ .Name | title }}`,
+	"imageUrl":            `{{ .Chart.Icon }}`,
+	"documentationUrl":    `{{ .Chart.Home }}`,
+	"providerDisplayName": `{{ range $i, $m := .Chart.Maintainers }}{{ if $i }}, {{ end }}{{ $m.Name }}{{ end }}`,
+	"longDescription":     `{{ .Chart.Description }}`,
+}
+
+var defaultPlanMetadataTemplates = map[string]string{
+	"displayName": `{{ .Service | title }} {{ if .Latest }}latest, currently {{ end }}{{ .Chart.AppVersion }}{{ with .Tier }} ({{ .Name }}){{ end }}`,
+}
+
+var defaultPlanBulletTemplates = []string{
+	`Chart version {{ .Chart.Version }}`,
+	`App version {{ .Chart.AppVersion }}`,
+	`{{ if .Chart.Deprecated }}Deprecated{{ end }}`,
+}
+
+// metadataRenderer renders the metadata of the catalog entries.
+type metadataRenderer struct {
+	service     map[string]*template.Template
+	plan        map[string]*template.Template
+	planBullets []*template.Template
+}
+
+// serviceMetadataData is the data the service metadata templates are rendered with.
+type serviceMetadataData struct {
+	Name  string
+	Chart *chart.Metadata
+}
+
+// planMetadataData is the data the plan metadata templates are rendered with.
+type planMetadataData struct {
+	Service string
+	Name    string
+	Chart   *chart.Metadata
+	Tier    *Tier
+	Latest  bool
+}
+
+// newMetadataRenderer parses the metadata templates over the default ones. A nil templates
+// renders the default metadata.
+func newMetadataRenderer(templates *MetadataTemplates) (*metadataRenderer, error) {
+	if templates == nil {
+		templates = &MetadataTemplates{}
+	}
+	r := &metadataRenderer{}
+	var err error
+	if r.service, err = parseMetadataTemplates("service", defaultServiceMetadataTemplates, templates.Service); err != nil {
+		return nil, err
+	}
+	if r.plan, err = parseMetadataTemplates("plan", defaultPlanMetadataTemplates, templates.Plan); err != nil {
+		return nil, err
+	}
+
+	bullets := templates.PlanBullets
+	if len(bullets) == 0 {
+		bullets = defaultPlanBulletTemplates
+	}
+	for i, text := range bullets {
+		tmpl, err := parseMetadataTemplate(fmt.Sprintf("plan bullet %d", i), text)
+		if err != nil {
+			return nil, err
+		}
+		r.planBullets = append(r.planBullets, tmpl)
+	}
+	return r, nil
+}
+
+// parseMetadataTemplates parses the templates of the metadata fields of a kind of catalog entry.
+func parseMetadataTemplates(kind string, defaults, overrides map[string]string) (map[string]*template.Template, error) {
+	texts := make(map[string]string, len(defaults)+len(overrides))
+	for field, text := range defaults {
+		texts[field] = text
+	}
+	for field, text := range overrides {
+		texts[field] = text
+	}
+
+	templates := make(map[string]*template.Template, len(texts))
+	for field, text := range texts {
+		if text == "" {
+			continue
+		}
+		tmpl, err := parseMetadataTemplate(fmt.Sprintf("%s %s", kind, field), text)
+		if err != nil {
+			return nil, err
+		}
+		templates[field] = tmpl
+	}
+	return templates, nil
+}
+
+func parseMetadataTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(sprig.TxtFuncMap()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the %s metadata template: %v", name, err)
+	}
+	return tmpl, nil
+}
+
+// serviceMetadata renders the metadata of a service from the latest version of its chart.
+func (r *metadataRenderer) serviceMetadata(name string, chartVersion *repo.ChartVersion) (map[string]interface{}, error) {
+	data := serviceMetadataData{Name: name, Chart: chartVersion.Metadata}
+	return renderMetadataFields(r.service, data)
+}
+
+// planMetadata renders the metadata of a plan from the chart version backing it.
+func (r *metadataRenderer) planMetadata(service, name string, chartVersion *repo.ChartVersion, tier *Tier, latest bool) (map[string]interface{}, error) {
+	data := planMetadataData{Service: service, Name: name, Chart: chartVersion.Metadata, Tier: tier, Latest: latest}
+	metadata, err := renderMetadataFields(r.plan, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var bullets []string
+	for _, tmpl := range r.planBullets {
+		bullet, err := renderMetadataTemplate(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		if bullet != "" {
+			bullets = append(bullets, bullet)
+		}
+	}
+	if len(bullets) > 0 {
+		metadata["bullets"] = bullets
+	}
+	return metadata, nil
+}
+
+// renderMetadataFields renders the metadata fields, leaving out the empty ones.
+func renderMetadataFields(templates map[string]*template.Template, data interface{}) (map[string]interface{}, error) {
+	fields := make([]string, 0, len(templates))
+	for field := range templates {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	metadata := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		value, err := renderMetadataTemplate(templates[field], data)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			metadata[field] = value
+		}
+	}
+	return metadata, nil
+}
+
+func renderMetadataTemplate(tmpl *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render the %s metadata template: %v", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// mergeMetadata returns the generated metadata with the curated fields set over it.
+func mergeMetadata(generated, curated map[string]interface{}) map[string]interface{} {
+	for field, value := range curated {
+		generated[field] = value
+	}
+	return generated
+}
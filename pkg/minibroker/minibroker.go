@@ -22,10 +22,10 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
-	"regexp"
 	"strings"
+	"sync"
 
-	"github.com/Masterminds/semver"
+	"github.com/kubernetes-sigs/minibroker/pkg/audit"
 	"github.com/kubernetes-sigs/minibroker/pkg/helm"
 	"github.com/pkg/errors"
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
@@ -46,6 +46,9 @@ const (
 	ServiceKey          = "service-id"
 	PlanKey             = "plan-id"
 	ProvisionParamsKey  = "provision-params"
+	ChartKey            = "chart"
+	ChartVersionKey     = "chart-version"
+	AppVersionKey       = "app-version"
 	ReleaseNamespaceKey = "release-namespace"
 	HeritageLabel       = "heritage"
 	ReleaseLabel        = "release"
@@ -56,6 +59,7 @@ const (
 	OperationNameKey        = "last-operation-name"
 	OperationStateKey       = "last-operation-state"
 	OperationDescriptionKey = "last-operation-description"
+	OperationIdentityKey    = "last-operation-identity"
 )
 
 // Error code constants missing from go-open-service-broker-client
@@ -73,8 +77,9 @@ const (
 )
 
 const (
-	BindingKeyPrefix      = "binding-"
-	BindingStateKeyPrefix = "binding-state-"
+	BindingKeyPrefix         = "binding-"
+	BindingStateKeyPrefix    = "binding-state-"
+	BindingIdentityKeyPrefix = "binding-identity-"
 )
 
 type Client struct {
@@ -83,29 +88,69 @@ type Client struct {
 	coreClient                kubernetes.Interface
 	providers                 map[string]Provider
 	serviceCatalogEnabledOnly bool
+	// The optional curated catalog.
+	catalogConfig *CatalogConfig
+	// The tiers sizing the plans, by chart name.
+	tiers map[string][]Tier
+	// The catalog built from the Helm repository index by Init. It is replaced by its next
+	// generation once the chart schemas are loaded in the background.
+	catalogMu sync.RWMutex
+	catalog   *catalog
+	// Builds the hosts of the credentials.
+	hostBuilder hostBuilder
 }
 
 func NewClient(
 	namespace string,
 	serviceCatalogEnabledOnly bool,
 	clusterDomain string,
+	catalogConfig *CatalogConfig,
+	tiers map[string][]Tier,
+	credentialMappings map[string]*CredentialMapping,
+	plugins map[string]*PluginProvider,
 ) *Client {
 	klog.V(5).Infof("minibroker: initializing a new client")
 	hb := hostBuilder{clusterDomain}
-	return &Client{
+	client := &Client{
 		helm:                      helm.NewDefaultClient(),
 		coreClient:                loadInClusterClient(),
 		namespace:                 namespace,
 		serviceCatalogEnabledOnly: serviceCatalogEnabledOnly,
+		catalogConfig:             catalogConfig,
+		tiers:                     tiers,
+		hostBuilder:               hb,
 		providers: map[string]Provider{
-			"mysql":      MySQLProvider{hb},
-			"mariadb":    MariadbProvider{hb},
-			"postgresql": PostgresProvider{hb},
-			"mongodb":    MongodbProvider{hb},
-			"redis":      RedisProvider{hb},
-			"rabbitmq":   RabbitmqProvider{hb},
+			"mysql":           MySQLProvider{hb},
+			"mariadb":         MariadbProvider{hb},
+			"postgresql":      PostgresProvider{hb},
+			"mongodb":         MongodbProvider{hb},
+			"mongodb-sharded": MongodbProvider{hb},
+			"redis":           RedisProvider{hb},
+			"redis-cluster":   RedisProvider{hb},
+			"rabbitmq":        RabbitmqProvider{hb},
 		},
 	}
+	client.registerCredentialMappings(hb, credentialMappings)
+	client.registerProviderPlugins(hb, plugins)
+	return client
+}
+
+// registerCredentialMappings registers a MappingProvider for the chart of each credential mapping,
+// replacing the built-in provider of the chart if any.
+func (c *Client) registerCredentialMappings(hb hostBuilder, credentialMappings map[string]*CredentialMapping) {
+	for chart, mapping := range credentialMappings {
+		c.providers[chart] = MappingProvider{hb, mapping}
+	}
+}
+
+// registerProviderPlugins registers the provider plugins, replacing the built-in provider or the
+// credential mapping of their charts if any.
+func (c *Client) registerProviderPlugins(hb hostBuilder, plugins map[string]*PluginProvider) {
+	for chart, plugin := range plugins {
+		provider := *plugin
+		provider.hostBuilder = hb
+		c.providers[chart] = provider
+	}
 }
 
 func loadInClusterClient() kubernetes.Interface {
@@ -122,8 +167,83 @@ func loadInClusterClient() kubernetes.Interface {
 	return clientset
 }
 
+// Init initializes the Helm repository and builds the catalog from its index, validating the
+// curated catalog if any. No chart is downloaded here: the values schemas of the chart versions
+// backing the plans are loaded when first validating provisioning parameters, or in the background
+// by publishChartSchemas when the curated catalog publishes them.
 func (c *Client) Init(repoURL string) error {
-	return c.helm.Initialize(repoURL)
+	if err := c.helm.Initialize(repoURL); err != nil {
+		return err
+	}
+	var kubeVersion string
+	if c.catalogConfig.matchesKubeVersion() {
+		kubeVersion = c.serverVersion()
+	}
+	catalog, err := buildCatalog(c.helm.ListCharts(), c.catalogConfig, c.tiers, kubeVersion, c.isOffered, c.hasCredentials)
+	if err != nil {
+		return fmt.Errorf("failed to initialize minibroker: %v", err)
+	}
+	var schemas map[string]map[string]interface{}
+	if c.catalogConfig != nil {
+		schemas = c.catalogConfig.ParameterSchemas
+	}
+	if err := catalog.addPlanSchemas(schemas, c.helm.ChartClient().Load); err != nil {
+		return fmt.Errorf("failed to initialize minibroker: %v", err)
+	}
+	c.catalogMu.Lock()
+	if c.catalog != nil {
+		catalog.generation = c.catalog.generation + 1
+	}
+	c.catalog = catalog
+	c.catalogMu.Unlock()
+	if c.catalogConfig != nil && c.catalogConfig.PublishChartSchemas {
+		go c.publishChartSchemas(catalog)
+	}
+	return nil
+}
+
+// publishChartSchemas loads the values schemas of the chart versions backing the plans of catalog,
+// and replaces it with its next generation publishing them, unless it was replaced meanwhile.
+func (c *Client) publishChartSchemas(catalog *catalog) {
+	klog.V(3).Infof("minibroker: loading the chart schemas of the plans")
+	loaded := catalog.loadPlanSchemas()
+	c.catalogMu.Lock()
+	defer c.catalogMu.Unlock()
+	if c.catalog != catalog {
+		return
+	}
+	c.catalog = loaded
+	klog.V(3).Infof("minibroker: published the chart schemas of the plans")
+}
+
+// currentCatalog returns the catalog served.
+func (c *Client) currentCatalog() *catalog {
+	c.catalogMu.RLock()
+	defer c.catalogMu.RUnlock()
+	return c.catalog
+}
+
+// isOffered returns whether a chart is offered when no curated catalog is used.
+func (c *Client) isOffered(chart string) bool {
+	_, ok := c.providers[chart]
+	return ok || !c.serviceCatalogEnabledOnly
+}
+
+// hasCredentials returns whether binding an instance of chart produces usable credentials, i.e.
+// whether a provider handles the chart.
+func (c *Client) hasCredentials(chart string) bool {
+	_, ok := c.providers[chart]
+	return ok
+}
+
+// serverVersion returns the version of the cluster, or an empty string when it can't be found.
+func (c *Client) serverVersion() string {
+	version, err := c.coreClient.Discovery().ServerVersion()
+	if err != nil {
+		klog.Warningf("minibroker: not matching the kubeVersion of the charts: failed to get the cluster version: %v", err)
+		return ""
+	}
+	return version.GitVersion
 }
 
 func hasTag(tag string, list []string) bool {
@@ -178,6 +298,20 @@ func generateOperationName(prefix string) string {
 	return fmt.Sprintf("%s%x", prefix, rand.Int31())
 }
 
+// identityConfigMapValue serializes the originating identity of an operation to be stored in the
+// instance configmap. A nil value is returned for a nil identity, removing any previous value when
+// passed to updateConfigMap.
+func identityConfigMapValue(identity *audit.Identity) (interface{}, error) {
+	if identity == nil {
+		return nil, nil
+	}
+	identityJSON, err := json.Marshal(identity)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal the originating identity")
+	}
+	return string(identityJSON), nil
+}
+
 func (c *Client) getConfigMap(instanceID string) (*corev1.ConfigMap, error) {
 	configMapInterface := c.coreClient.CoreV1().ConfigMaps(c.namespace)
 	config, err := configMapInterface.Get(context.TODO(), instanceID, metav1.GetOptions{})
@@ -210,99 +344,65 @@ func (c *Client) updateConfigMap(instanceID string, data map[string]interface{})
 	configMapInterface := c.coreClient.CoreV1().ConfigMaps(c.namespace)
 	_, err = configMapInterface.Update(context.TODO(), config, metav1.UpdateOptions{})
 	if err != nil {
-		return errors.Wrapf(err, "Failed to update config for instance %q", instanceID)
+		return errors.Wrapf(newKubernetesError(err), "Failed to update config for instance %q", instanceID)
 	}
 	return nil
 }
 
 func (c *Client) ListServices() ([]osb.Service, error) {
 	klog.V(4).Infof("minibroker: listing services")
-
-	var services []osb.Service
-
-	charts := c.helm.ListCharts()
-	for chart, chartVersions := range charts {
-		if _, ok := c.providers[chart]; !ok && c.serviceCatalogEnabledOnly {
-			continue
-		}
-
-		tags := getTagIntersection(chartVersions)
-
-		svc := osb.Service{
-			ID:          chart,
-			Name:        chart,
-			Description: "Helm Chart for " + chart,
-			Bindable:    true,
-			Plans:       make([]osb.Plan, 0, len(chartVersions)),
-			Tags:        tags,
-		}
-		appVersions := map[string]*repo.ChartVersion{}
-		for _, chartVersion := range chartVersions {
-			if chartVersion.AppVersion == "" {
-				continue
-			}
-
-			curV, err := semver.NewVersion(chartVersion.Version)
-			if err != nil {
-				klog.V(4).Infof("minibroker: skipping %s@%s because %q is not a valid semver", chart, chartVersion.AppVersion, chartVersion.Version)
-				continue
-			}
-
-			currentMax, ok := appVersions[chartVersion.AppVersion]
-			if !ok {
-				appVersions[chartVersion.AppVersion] = chartVersion
-			} else {
-				maxV, _ := semver.NewVersion(currentMax.Version)
-				if curV.GreaterThan(maxV) {
-					appVersions[chartVersion.AppVersion] = chartVersion
-				} else {
-					klog.V(4).Infof("minibroker: skipping %s@%s because %s < %s", chart, chartVersion.AppVersion, curV, maxV)
-					continue
-				}
-			}
-		}
-
-		for _, chartVersion := range appVersions {
-			planToken := fmt.Sprintf("%s@%s", chart, chartVersion.AppVersion)
-			cleaner := regexp.MustCompile(`[^a-z0-9]`)
-			planID := cleaner.ReplaceAllString(strings.ToLower(planToken), "-")
-			planName := cleaner.ReplaceAllString(chartVersion.AppVersion, "-")
-			plan := osb.Plan{
-				ID:          planID,
-				Name:        planName,
-				Description: chartVersion.Description,
-				Free:        boolPtr(true),
-			}
-			svc.Plans = append(svc.Plans, plan)
-		}
-
-		if len(svc.Plans) == 0 {
-			continue
-		}
-		services = append(services, svc)
-	}
-
+	services := c.currentCatalog().services
 	klog.V(4).Infof("minibroker: listed services")
 
 	return services, nil
 }
 
+// CatalogGeneration returns the generation of the catalog listed by ListServices. It changes every
+// time the catalog is built, so that the catalog responses rendered for a generation can be reused.
+func (c *Client) CatalogGeneration() uint64 {
+	return c.currentCatalog().generation
+}
+
+// ServiceChart returns the name of the chart backing a service of the catalog. Legacy service IDs,
+// i.e. chart names, are also accepted.
+func (c *Client) ServiceChart(serviceID string) (string, bool) {
+	return c.currentCatalog().chart(serviceID)
+}
+
 // Provision a new service instance.  Returns the async operation key (if
-// acceptsIncomplete is set).
-func (c *Client) Provision(instanceID, serviceID, planID, namespace string, acceptsIncomplete bool, provisionParams *ProvisionParams) (string, error) {
+// acceptsIncomplete is set). The originating identity, if any, is stored with
+// the instance.
+func (c *Client) Provision(instanceID, serviceID, planID, namespace string, acceptsIncomplete bool, provisionParams *ProvisionParams, identity *audit.Identity) (string, error) {
 	klog.V(3).Infof("minibroker: provisioning intance %q, service %q, namespace %q, params %v", instanceID, serviceID, namespace, provisionParams)
 	ctx := context.TODO()
 
-	chartName := serviceID
-	// The way I'm turning charts into plans is not reversible
-	chartVersion := strings.Replace(planID, serviceID+"-", "", 1)
-	chartVersion = strings.Replace(chartVersion, "-", ".", -1)
+	plan, err := c.resolvePlan(serviceID, planID)
+	if err != nil {
+		return "", err
+	}
+	chartDef := plan.chartVersion
+	if plan.latest {
+		// The chart version is recorded with the instance, which is never upgraded when the latest plan
+		// moves on.
+		klog.V(3).Infof("minibroker: resolved the latest plan of service %q to %s@%s", serviceID, chartDef.Name, chartDef.Version)
+	}
+	if plan.tier != nil {
+		// The tier values are installed, and persisted for binding, under the user parameters.
+		provisionParams = NewProvisionParams(mergeValues(plan.tier.Values, provisionParams.Object))
+	}
+	if err := c.validateProvisionParams(plan, provisionParams); err != nil {
+		return "", err
+	}
 
 	klog.V(4).Infof("minibroker: persisting the provisioning parameters")
 	paramsJSON, err := json.Marshal(provisionParams)
 	if err != nil {
 		return "", errors.Wrapf(err, "could not marshall provisioning parameters %v", provisionParams)
 	}
+	identityValue, err := identityConfigMapValue(identity)
+	if err != nil {
+		return "", err
+	}
 	config := corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      instanceID,
@@ -316,8 +416,14 @@ func (c *Client) Provision(instanceID, serviceID, planID, namespace string, acce
 			ProvisionParamsKey: string(paramsJSON),
 			ServiceKey:         serviceID,
 			PlanKey:            planID,
+			ChartKey:           chartDef.Name,
+			ChartVersionKey:    chartDef.Version,
+			AppVersionKey:      chartDef.AppVersion,
 		},
 	}
+	if identityValue != nil {
+		config.Data[OperationIdentityKey] = identityValue.(string)
+	}
 
 	_, err = c.coreClient.CoreV1().
 		ConfigMaps(config.Namespace).
@@ -331,7 +437,7 @@ func (c *Client) Provision(instanceID, serviceID, planID, namespace string, acce
 				Description:  &[]string{ConcurrencyErrorDescription}[0],
 			}
 		}
-		return "", errors.Wrapf(err, "could not persist the instance configmap for %q", instanceID)
+		return "", errors.Wrapf(newKubernetesError(err), "could not persist the instance configmap for %q", instanceID)
 	}
 
 	if acceptsIncomplete {
@@ -345,7 +451,7 @@ func (c *Client) Provision(instanceID, serviceID, planID, namespace string, acce
 			return "", errors.Wrapf(err, "Failed to set operation key when provisioning instance %q", instanceID)
 		}
 		go func() {
-			err = c.provisionSynchronously(instanceID, namespace, serviceID, planID, chartName, chartVersion, provisionParams)
+			err = c.provisionSynchronously(instanceID, namespace, serviceID, planID, chartDef, provisionParams)
 			if err == nil {
 				err = c.updateConfigMap(instanceID, map[string]interface{}{
 					OperationStateKey:       string(osb.StateSucceeded),
@@ -355,7 +461,7 @@ func (c *Client) Provision(instanceID, serviceID, planID, namespace string, acce
 				klog.V(2).Infof("minibroker: failed to provision %q: %v", instanceID, err)
 				err = c.updateConfigMap(instanceID, map[string]interface{}{
 					OperationStateKey:       string(osb.StateFailed),
-					OperationDescriptionKey: fmt.Sprintf("service instance %q failed to provision", instanceID),
+					OperationDescriptionKey: fmt.Sprintf("service instance %q failed to provision: %s", instanceID, DescribeError(err)),
 				})
 				if err != nil {
 					klog.V(2).Infof("minibroker: failed to provision %q: could not update operation state when provisioning asynchronously: %v", instanceID, err)
@@ -365,7 +471,7 @@ func (c *Client) Provision(instanceID, serviceID, planID, namespace string, acce
 		return operationKey, nil
 	}
 
-	err = c.provisionSynchronously(instanceID, namespace, serviceID, planID, chartName, chartVersion, provisionParams)
+	err = c.provisionSynchronously(instanceID, namespace, serviceID, planID, chartDef, provisionParams)
 	if err != nil {
 		return "", err
 	}
@@ -373,18 +479,50 @@ func (c *Client) Provision(instanceID, serviceID, planID, namespace string, acce
 	return "", nil
 }
 
-// provisionSynchronously will provision the service instance synchronously.
-func (c *Client) provisionSynchronously(instanceID, namespace, serviceID, planID, chartName, chartVersion string, provisionParams *ProvisionParams) error {
-	klog.V(3).Infof("minibroker: provisioning %s/%s using helm chart %s@%s", serviceID, planID, chartName, chartVersion)
+// resolvePlan returns a plan of the catalog. Legacy plans missing from the catalog, e.g.
+// for app versions no longer offered, are resolved from the app version encoded in their ID.
+func (c *Client) resolvePlan(serviceID, planID string) (catalogPlan, error) {
+	catalog := c.currentCatalog()
+	chart, ok := catalog.chart(serviceID)
+	if !ok {
+		return catalogPlan{}, badRequestErrorf("service %q not found in the catalog", serviceID)
+	}
+	if plan, ok := catalog.plan(serviceID, planID); ok {
+		return plan, nil
+	}
+	if chart != serviceID {
+		return catalogPlan{}, badRequestErrorf("plan %q of service %q not found in the catalog", planID, serviceID)
+	}
 
-	chartDef, err := c.helm.GetChart(chartName, chartVersion)
+	// The app version can't always be recovered from legacy plan IDs, e.g. when it contains dashes.
+	appVersion := strings.Replace(planID, serviceID+"-", "", 1)
+	appVersion = strings.Replace(appVersion, "-", ".", -1)
+	chartDef, err := c.helm.GetChart(chart, appVersion)
 	if err != nil {
-		return err
+		return catalogPlan{}, newHelmError(http.StatusBadRequest, err)
 	}
+	return catalogPlan{chart: chart, chartVersion: chartDef}, nil
+}
+
+// instanceChart returns the name of the chart installed for an instance. Instances provisioned by
+// older releases don't record it, but their service ID is the chart name.
+func (c *Client) instanceChart(config *corev1.ConfigMap, serviceID string) string {
+	if chart, ok := config.Data[ChartKey]; ok {
+		return chart
+	}
+	if chart, ok := c.currentCatalog().chart(serviceID); ok {
+		return chart
+	}
+	return serviceID
+}
+
+// provisionSynchronously will provision the service instance synchronously.
+func (c *Client) provisionSynchronously(instanceID, namespace, serviceID, planID string, chartDef *repo.ChartVersion, provisionParams *ProvisionParams) error {
+	klog.V(3).Infof("minibroker: provisioning %s/%s using helm chart %s@%s", serviceID, planID, chartDef.Name, chartDef.Version)
 
 	release, err := c.helm.ChartClient().Install(chartDef, namespace, provisionParams.Object)
 	if err != nil {
-		return err
+		return newHelmError(http.StatusInternalServerError, err)
 	}
 
 	// Store any required metadata necessary for bind and deprovision as labels on the resources itself
@@ -396,7 +534,7 @@ func (c *Client) provisionSynchronously(instanceID, namespace, serviceID, planID
 	}
 	services, err := c.coreClient.CoreV1().Services(namespace).List(context.TODO(), filterByRelease)
 	if err != nil {
-		return err
+		return errors.Wrapf(newKubernetesError(err), "could not list the services of release %q", release.Name)
 	}
 	for _, service := range services.Items {
 		err := c.labelService(service, instanceID)
@@ -406,7 +544,7 @@ func (c *Client) provisionSynchronously(instanceID, namespace, serviceID, planID
 	}
 	secrets, err := c.coreClient.CoreV1().Secrets(namespace).List(context.TODO(), filterByRelease)
 	if err != nil {
-		return err
+		return errors.Wrapf(newKubernetesError(err), "could not list the secrets of release %q", release.Name)
 	}
 	for _, secret := range secrets.Items {
 		err := c.labelSecret(secret, instanceID)
@@ -424,7 +562,7 @@ func (c *Client) provisionSynchronously(instanceID, namespace, serviceID, planID
 	}
 
 	klog.V(4).Infof("minibroker: provisioned %v@%v (%v@%v)",
-		chartName, chartVersion, release.Name, release.Version)
+		chartDef.Name, chartDef.Version, release.Name, release.Version)
 
 	return nil
 }
@@ -452,7 +590,7 @@ func (c *Client) labelService(service corev1.Service, instanceID string) error {
 		Services(service.Namespace).
 		Patch(ctx, service.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
 	if err != nil {
-		return errors.Wrapf(err, "failed to label service %s/%s with service metadata", service.Namespace, service.Name)
+		return errors.Wrapf(newKubernetesError(err), "failed to label service %s/%s with service metadata", service.Namespace, service.Name)
 	}
 
 	return nil
@@ -481,15 +619,16 @@ func (c *Client) labelSecret(secret corev1.Secret, instanceID string) error {
 		Secrets(secret.Namespace).
 		Patch(ctx, secret.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
 	if err != nil {
-		return errors.Wrapf(err, "failed to label secret %s/%s with service metadata", secret.Namespace, secret.Name)
+		return errors.Wrapf(newKubernetesError(err), "failed to label secret %s/%s with service metadata", secret.Namespace, secret.Name)
 	}
 
 	return nil
 }
 
 // Bind the given service instance (of the given service) asynchronously; the
-// binding operation key is returned.
-func (c *Client) Bind(instanceID, serviceID, bindingID string, acceptsIncomplete bool, bindParams *BindParams) (string, error) {
+// binding operation key is returned. The originating identity, if any, is
+// stored with the binding.
+func (c *Client) Bind(instanceID, serviceID, bindingID string, acceptsIncomplete bool, bindParams *BindParams, identity *audit.Identity) (string, error) {
 	klog.V(3).Infof("minibroker: binding instance %q, service %q, binding %q, binding params %v", instanceID, serviceID, bindingID, bindParams)
 	config, err := c.getConfigMap(instanceID)
 	if err != nil {
@@ -500,10 +639,11 @@ func (c *Client) Bind(instanceID, serviceID, bindingID string, acceptsIncomplete
 				ErrorMessage: &msg,
 			}
 		}
-		return "", err
+		return "", newKubernetesError(err)
 	}
 	releaseNamespace := config.Data[ReleaseNamespaceKey]
 	rawProvisionParams := config.Data[ProvisionParamsKey]
+	chart := c.instanceChart(config, serviceID)
 	operationName := generateOperationName(OperationPrefixBind)
 
 	var provisionParams *ProvisionParams
@@ -511,17 +651,25 @@ func (c *Client) Bind(instanceID, serviceID, bindingID string, acceptsIncomplete
 	if err != nil {
 		return "", errors.Wrapf(err, "could not unmarshall provision parameters for instance %q", instanceID)
 	}
+	identityValue, err := identityConfigMapValue(identity)
+	if err != nil {
+		return "", err
+	}
+	if err := c.validateBindParams(chart, bindParams); err != nil {
+		return "", err
+	}
 
 	if acceptsIncomplete {
 		klog.V(3).Infof("minibroker: initializing asynchronous binding %q", bindingID)
 		go func() {
 			_ = c.bindSynchronously(
 				instanceID,
-				serviceID,
+				chart,
 				bindingID,
 				releaseNamespace,
 				bindParams,
 				provisionParams,
+				identityValue,
 			)
 			klog.V(3).Infof("minibroker: asynchronously bound instance %q, service %q, binding %q", instanceID, serviceID, bindingID)
 		}()
@@ -531,11 +679,12 @@ func (c *Client) Bind(instanceID, serviceID, bindingID string, acceptsIncomplete
 	klog.V(3).Infof("minibroker: initializing synchronous binding %q", bindingID)
 	if err := c.bindSynchronously(
 		instanceID,
-		serviceID,
+		chart,
 		bindingID,
 		releaseNamespace,
 		bindParams,
 		provisionParams,
+		identityValue,
 	); err != nil {
 		return "", err
 	}
@@ -546,64 +695,85 @@ func (c *Client) Bind(instanceID, serviceID, bindingID string, acceptsIncomplete
 }
 
 // bindSynchronously creates a new binding for the given service instance.  All
-// results are only reported via the service instance configmap (under the
+// results are reported via the service instance configmap (under the
 // appropriate key for the binding) for lookup by LastBindingOperationState().
+// The binding error, if any, is also returned.
 func (c *Client) bindSynchronously(
 	instanceID,
-	serviceID,
+	chart,
 	bindingID,
 	releaseNamespace string,
 	bindParams *BindParams,
 	provisionParams *ProvisionParams,
+	identityValue interface{},
 ) error {
 	ctx := context.TODO()
 
 	// Wrap most of the code in an inner function to simplify error handling
 	err := func() error {
-		filterByInstance := metav1.ListOptions{
-			LabelSelector: labels.SelectorFromSet(map[string]string{
-				InstanceLabel: instanceID,
-			}).String(),
-		}
-
-		services, err := c.coreClient.CoreV1().
-			Services(releaseNamespace).
-			List(ctx, filterByInstance)
-		if err != nil {
-			return err
-		}
-		if len(services.Items) == 0 {
-			return osb.HTTPStatusCodeError{StatusCode: http.StatusNotFound}
-		}
-
-		secrets, err := c.coreClient.CoreV1().
-			Secrets(releaseNamespace).
-			List(ctx, filterByInstance)
+		services, secrets, err := c.instanceResources(ctx, instanceID, releaseNamespace)
 		if err != nil {
 			return err
 		}
-		if len(secrets.Items) == 0 {
-			return osb.HTTPStatusCodeError{StatusCode: http.StatusNotFound}
+		// Apply additional provisioning logic for Service Catalog Enabled services. The credentials of
+		// other charts are built from the annotations of their resources, if any. Only the charts
+		// bound by neither get the content of their secrets, as the secrets of the others hold
+		// admin passwords and private keys the binding must not hand out.
+		provider, ok := c.providers[chart]
+		if !ok && hasCredentialAnnotations(services.Items, secrets.Items) {
+			provider, ok = AnnotationProvider{c.hostBuilder, secrets.Items}, true
 		}
-
 		data := make(Object)
-		for _, secret := range secrets.Items {
-			for key, value := range secret.Data {
-				data[key] = string(value)
+		if !ok {
+			// The secrets can only be given as environment variables, the other formats requiring a
+			// provider.
+			if data, err = formatCredentials(chart, nil, bindParams, chartSecrets(secrets.Items)); err != nil {
+				return errors.Wrapf(newProviderError(err), "unable to bind instance %s", instanceID)
+			}
+		} else {
+			// The secrets referenced by the provider are not part of the credentials.
+			providerSecrets, err := c.providerSecrets(ctx, provider, releaseNamespace, provisionParams, secrets.Items)
+			if err != nil {
+				return err
+			}
+			var creds Object
+			if binder, ok := provider.(EndpointsBinder); ok {
+				endpoints, err := c.headlessEndpoints(ctx, releaseNamespace, services.Items)
+				if err != nil {
+					return err
+				}
+				creds, err = binder.BindWithEndpoints(
+					services.Items,
+					endpoints,
+					bindParams,
+					provisionParams,
+					chartSecrets(providerSecrets),
+				)
+			} else {
+				creds, err = provider.Bind(
+					services.Items,
+					bindParams,
+					provisionParams,
+					chartSecrets(providerSecrets),
+				)
 			}
-		}
-
-		// Apply additional provisioning logic for Service Catalog Enabled services
-		provider, ok := c.providers[serviceID]
-		if ok {
-			creds, err := provider.Bind(
-				services.Items,
-				bindParams,
-				provisionParams,
-				data,
-			)
 			if err != nil {
-				return errors.Wrapf(err, "unable to bind instance %s", instanceID)
+				return errors.Wrapf(newProviderError(err), "unable to bind instance %s", instanceID)
+			}
+			if hooks, ok := provider.(BindingHooks); ok {
+				binding := &Binding{
+					ID:              bindingID,
+					Services:        services.Items,
+					BindParams:      bindParams,
+					ProvisionParams: provisionParams,
+					ChartSecrets:    chartSecrets(providerSecrets),
+				}
+				if creds, err = hooks.OnBind(binding, creds); err != nil {
+					return errors.Wrapf(newProviderError(err), "unable to bind instance %s", instanceID)
+				}
+			}
+			if creds, err = formatCredentials(chart, provider, bindParams, creds); err != nil {
+				return errors.Wrapf(newProviderError(err), "unable to bind instance %s", instanceID)
 			}
 			for k, v := range creds {
 				data[k] = v
@@ -636,7 +806,7 @@ func (c *Client) bindSynchronously(
 	} else {
 		klog.V(2).Infof("minibroker: error binding instance %q: %v", instanceID, err)
 		operationState.State = osb.StateFailed
-		operationState.Description = strPtr(fmt.Sprintf("Failed to bind instance %q", instanceID))
+		operationState.Description = strPtr(fmt.Sprintf("Failed to bind instance %q: %s", instanceID, DescribeError(err)))
 	}
 	operationStateJSON, marshalError := json.Marshal(operationState)
 	if marshalError != nil {
@@ -647,27 +817,33 @@ func (c *Client) bindSynchronously(
 		return marshalError
 	}
 	updates := map[string]interface{}{
-		(BindingStateKeyPrefix + bindingID): string(operationStateJSON),
+		(BindingStateKeyPrefix + bindingID):    string(operationStateJSON),
+		(BindingIdentityKeyPrefix + bindingID): identityValue,
 	}
 	updateError := c.updateConfigMap(instanceID, updates)
 	if updateError != nil {
-		klog.V(2).Infof("minibroker: error updating bind status: %v", marshalError)
+		klog.V(2).Infof("minibroker: error updating bind status: %v", updateError)
 		if err != nil {
 			return err
 		}
 		return updateError
 	}
-	return nil
+	return err
 }
 
 // Unbind a previously-bound instance binding.
 func (c *Client) Unbind(instanceID, bindingID string) error {
 	klog.V(3).Infof("minibroker: unbinding instance %q binding %q", instanceID, bindingID)
 
-	// The only clean up we need to do is to remove the binding information.
+	if err := c.unbindProvider(instanceID, bindingID); err != nil {
+		return err
+	}
+
+	// The binding information is removed once the provider cleaned up.
 	data := map[string]interface{}{
-		(BindingStateKeyPrefix + bindingID): nil,
-		(BindingKeyPrefix + bindingID):      nil,
+		(BindingStateKeyPrefix + bindingID):    nil,
+		(BindingKeyPrefix + bindingID):         nil,
+		(BindingIdentityKeyPrefix + bindingID): nil,
 	}
 	if err := c.updateConfigMap(instanceID, data); err != nil {
 		return err
@@ -678,6 +854,121 @@ func (c *Client) Unbind(instanceID, bindingID string) error {
 	return nil
 }
 
+// unbindProvider runs the unbind hook of the provider of the instance, if any, so that the resources
+// of the binding are deleted.
+func (c *Client) unbindProvider(instanceID, bindingID string) error {
+	config, err := c.getConfigMap(instanceID)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return newKubernetesError(err)
+	}
+	provider := c.providers[c.instanceChart(config, config.Data[ServiceKey])]
+	hooks, ok := provider.(BindingHooks)
+	if !ok {
+		return nil
+	}
+	jsonData, ok := config.Data[BindingKeyPrefix+bindingID]
+	if !ok {
+		return nil
+	}
+	var bindingResponse osb.GetBindingResponse
+	if err := json.Unmarshal([]byte(jsonData), &bindingResponse); err != nil {
+		return errors.Wrapf(err, "could not decode binding data")
+	}
+	var provisionParams *ProvisionParams
+	if err := json.Unmarshal([]byte(config.Data[ProvisionParamsKey]), &provisionParams); err != nil {
+		return errors.Wrapf(err, "could not unmarshall provision parameters for instance %q", instanceID)
+	}
+
+	ctx := context.TODO()
+	releaseNamespace := config.Data[ReleaseNamespaceKey]
+	services, secrets, err := c.instanceResources(ctx, instanceID, releaseNamespace)
+	if err != nil {
+		return err
+	}
+	providerSecrets, err := c.providerSecrets(ctx, provider, releaseNamespace, provisionParams, secrets.Items)
+	if err != nil {
+		return err
+	}
+	binding := &Binding{
+		ID:              bindingID,
+		Services:        services.Items,
+		BindParams:      NewBindParams(bindingResponse.Parameters),
+		ProvisionParams: provisionParams,
+		ChartSecrets:    chartSecrets(providerSecrets),
+	}
+	if err := hooks.OnUnbind(binding, bindingResponse.Credentials); err != nil {
+		return errors.Wrapf(newProviderError(err), "unable to unbind instance %s", instanceID)
+	}
+	return nil
+}
+
+// instanceResources lists the services and secrets of an instance. Instances without either are
+// reported as not found.
+func (c *Client) instanceResources(ctx context.Context, instanceID, releaseNamespace string) (*corev1.ServiceList, *corev1.SecretList, error) {
+	filterByInstance := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			InstanceLabel: instanceID,
+		}).String(),
+	}
+
+	services, err := c.coreClient.CoreV1().
+		Services(releaseNamespace).
+		List(ctx, filterByInstance)
+	if err != nil {
+		return nil, nil, errors.Wrap(newKubernetesError(err), "could not list the instance services")
+	}
+	if len(services.Items) == 0 {
+		return nil, nil, notFoundErrorf("no services found for instance %q", instanceID)
+	}
+
+	secrets, err := c.coreClient.CoreV1().
+		Secrets(releaseNamespace).
+		List(ctx, filterByInstance)
+	if err != nil {
+		return nil, nil, errors.Wrap(newKubernetesError(err), "could not list the instance secrets")
+	}
+	if len(secrets.Items) == 0 {
+		return nil, nil, notFoundErrorf("no secrets found for instance %q", instanceID)
+	}
+	return services, secrets, nil
+}
+
+// headlessEndpoints returns the Endpoints of the headless services of an instance, listing the pods
+// backing them. Services without Endpoints are left out.
+func (c *Client) headlessEndpoints(ctx context.Context, releaseNamespace string, services []corev1.Service) ([]corev1.Endpoints, error) {
+	var endpoints []corev1.Endpoints
+	for _, service := range services {
+		if service.Spec.ClusterIP != corev1.ClusterIPNone {
+			continue
+		}
+		serviceEndpoints, err := c.coreClient.CoreV1().
+			Endpoints(releaseNamespace).
+			Get(ctx, service.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(newKubernetesError(err), "could not get the endpoints of service %q", service.Name)
+		}
+		endpoints = append(endpoints, *serviceEndpoints)
+	}
+	return endpoints, nil
+}
+
+// chartSecrets returns the data of the secrets of an instance.
+func chartSecrets(secrets []corev1.Secret) Object {
+	data := make(Object)
+	for _, secret := range secrets {
+		for key, value := range secret.Data {
+			data[key] = string(value)
+		}
+	}
+	return data
+}
+
 func (c *Client) GetBinding(instanceID, bindingID string) (*osb.GetBindingResponse, error) {
 	klog.V(3).Infof("minibroker: getting instance %q binding %q", instanceID, bindingID)
 
@@ -686,7 +977,7 @@ func (c *Client) GetBinding(instanceID, bindingID string) (*osb.GetBindingRespon
 		if apierrors.IsNotFound(err) {
 			return nil, osb.HTTPStatusCodeError{StatusCode: http.StatusNotFound}
 		}
-		return nil, errors.Wrapf(err, "failed to get service instance %q data", instanceID)
+		return nil, errors.Wrapf(newKubernetesError(err), "failed to get service instance %q data", instanceID)
 	}
 	jsonData, ok := config.Data[BindingKeyPrefix+bindingID]
 	if !ok {
@@ -703,7 +994,10 @@ func (c *Client) GetBinding(instanceID, bindingID string) (*osb.GetBindingRespon
 	return data, nil
 }
 
-func (c *Client) Deprovision(instanceID string, acceptsIncomplete bool) (string, error) {
+// Deprovision a service instance. Returns the async operation key (if
+// acceptsIncomplete is set). The originating identity, if any, is stored with
+// the instance while the deprovisioning is in progress.
+func (c *Client) Deprovision(instanceID string, acceptsIncomplete bool, identity *audit.Identity) (string, error) {
 	klog.V(3).Infof("minibroker: deprovisioning instance %q", instanceID)
 
 	ctx := context.TODO()
@@ -715,7 +1009,7 @@ func (c *Client) Deprovision(instanceID string, acceptsIncomplete bool) (string,
 		if apierrors.IsNotFound(err) {
 			return "", osb.HTTPStatusCodeError{StatusCode: http.StatusGone}
 		}
-		return "", err
+		return "", newKubernetesError(err)
 	}
 	release := config.Data[ReleaseLabel]
 	namespace := config.Data[ReleaseNamespaceKey]
@@ -730,11 +1024,16 @@ func (c *Client) Deprovision(instanceID string, acceptsIncomplete bool) (string,
 	}
 
 	klog.V(3).Infof("minibroker: asynchronously deprovisioning instance %q", instanceID)
+	identityValue, err := identityConfigMapValue(identity)
+	if err != nil {
+		return "", err
+	}
 	operationKey := generateOperationName(OperationPrefixDeprovision)
 	err = c.updateConfigMap(instanceID, map[string]interface{}{
 		OperationStateKey:       string(osb.StateInProgress),
 		OperationNameKey:        operationKey,
 		OperationDescriptionKey: fmt.Sprintf("deprovisioning service instance %q", instanceID),
+		OperationIdentityKey:    identityValue,
 	})
 	if err != nil {
 		return "", errors.Wrapf(err, "Failed to set operation key when deprovisioning instance %s", instanceID)
@@ -748,7 +1047,7 @@ func (c *Client) Deprovision(instanceID string, acceptsIncomplete bool) (string,
 		klog.V(2).Infof("minibroker: failed to deprovision %q: %v", instanceID, err)
 		err = c.updateConfigMap(instanceID, map[string]interface{}{
 			OperationStateKey:       string(osb.StateFailed),
-			OperationDescriptionKey: fmt.Sprintf("service instance %q failed to deprovision", instanceID),
+			OperationDescriptionKey: fmt.Sprintf("service instance %q failed to deprovision: %s", instanceID, DescribeError(err)),
 		})
 		if err != nil {
 			klog.V(2).Infof("minibroker: could not update operation state when deprovisioning asynchronously: %v", err)
@@ -762,14 +1061,14 @@ func (c *Client) deprovisionSynchronously(instanceID, releaseName, namespace str
 	ctx := context.TODO()
 
 	if err := c.helm.ChartClient().Uninstall(releaseName, namespace); err != nil {
-		return errors.Wrapf(err, "could not uninstall release %s", releaseName)
+		return errors.Wrapf(newHelmError(http.StatusInternalServerError, err), "could not uninstall release %s", releaseName)
 	}
 
 	err := c.coreClient.CoreV1().
 		ConfigMaps(c.namespace).
 		Delete(ctx, instanceID, metav1.DeleteOptions{})
 	if err != nil {
-		return errors.Wrapf(err, "could not delete configmap %s/%s", c.namespace, instanceID)
+		return errors.Wrapf(newKubernetesError(err), "could not delete configmap %s/%s", c.namespace, instanceID)
 	}
 
 	return nil
@@ -800,7 +1099,7 @@ func (c *Client) LastOperationState(instanceID string, operationKey *osb.Operati
 				StatusCode: http.StatusGone,
 			}
 		}
-		return nil, err
+		return nil, newKubernetesError(err)
 	}
 
 	if operationKey != nil && config.Data[OperationNameKey] != string(*operationKey) {
@@ -850,6 +1149,7 @@ func (c *Client) LastBindingOperationState(instanceID, bindingID string) (*osb.L
 				StatusCode: http.StatusGone,
 			}
 		}
+		return nil, newKubernetesError(err)
 	}
 
 	stateJSON, ok := config.Data[BindingStateKeyPrefix+bindingID]
@@ -19,68 +19,290 @@ package minibroker
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 )
 
 const (
-	mongodbProtocolName = "mongodb"
-	rootMongodbUsername = "root"
+	mongodbProtocolName      = "mongodb"
+	mongodbSRVProtocolName   = "mongodb+srv"
+	rootMongodbUsername      = "root"
+	mongodbAdminDatabase     = "admin"
+	defaultMongodbReplicaSet = "rs0"
 )
 
+// The MongoDB deployment topologies, as listed in the credentials.
+const (
+	mongodbStandalone = "standalone"
+	mongodbReplicaSet = "replicaset"
+	mongodbSharded    = "sharded"
+)
+
+// The names of the MongoDB ports across chart versions.
+var mongodbPortNames = []string{"mongodb", "tcp-mongodb"}
+
+// mongodbArbiterPod matches the names of the arbiter pods, e.g. mongodb-arbiter-0.
+var mongodbArbiterPod = regexp.MustCompile(`-arbiter-\d+$`)
+
+// mongodbTLS are the TLS parameters of the mongodb chart. Client certificates are not supported:
+// mutual TLS, enabled by default, must be disabled with tls.mTLS.enabled=false.
+var mongodbTLS = tlsParams{
+	enabled:         []string{"tls.enabled"},
+	existingSecret:  []string{"tls.existingSecret"},
+	caCert:          secretKeyParam{defaultKey: "mongodb-ca-cert"},
+	mutual:          []string{"tls.mTLS.enabled"},
+	mutualByDefault: true,
+}
+
 type MongodbProvider struct {
 	hostBuilder
 }
 
+// mongodbServices are the services of a MongoDB release, by role.
+type mongodbServices struct {
+	mongos   *corev1.Service
+	primary  *corev1.Service
+	headless *corev1.Service
+}
+
+// classifyMongodbServices sorts the services of a release by their role. The mongos routers of
+// sharded charts are told by the component in their selectors.
+func classifyMongodbServices(services []corev1.Service) *mongodbServices {
+	classified := &mongodbServices{}
+	for i := range services {
+		svc := &services[i]
+		component, ok := svc.Spec.Selector["app.kubernetes.io/component"]
+		if !ok {
+			component = svc.Spec.Selector["component"]
+		}
+		switch {
+		case component == "mongos":
+			classified.mongos = svc
+		case svc.Spec.ClusterIP == corev1.ClusterIPNone:
+			// Newer chart versions give the arbiters a headless service of their own.
+			if classified.headless == nil && !strings.HasSuffix(svc.Name, "-arbiter-headless") {
+				classified.headless = svc
+			}
+		case classified.primary == nil:
+			classified.primary = svc
+		}
+	}
+	return classified
+}
+
+// mongodbPort returns the MongoDB port of a service, defaulting to its first port.
+func mongodbPort(svc *corev1.Service) (corev1.ServicePort, error) {
+	if port, ok := findServicePort(svc, mongodbPortNames); ok {
+		return port, nil
+	}
+	if len(svc.Spec.Ports) == 0 {
+		return corev1.ServicePort{}, errors.Errorf("no ports found")
+	}
+	return svc.Spec.Ports[0], nil
+}
+
+// mongodbReplicaSetName returns the name of the replica set when the chart deploys one: with
+// architecture=replicaset in newer chart versions and replicaSet.enabled in older ones.
+func mongodbReplicaSetName(provisionParams *ProvisionParams) (string, bool, error) {
+	if architecture, _ := provisionParams.Dig("architecture"); architecture == mongodbReplicaSet {
+		name, err := provisionParams.DigStringOr("replicaSetName", defaultMongodbReplicaSet)
+		return name, true, err
+	}
+	if enabled, _ := provisionParams.Dig("replicaSet.enabled"); enabled == true {
+		name, err := provisionParams.DigStringOr("replicaSet.name", defaultMongodbReplicaSet)
+		return name, true, err
+	}
+	return "", false, nil
+}
+
+// mongodbMembers returns the hosts of the pods of the replica set, listed by the Endpoints of its
+// headless service. Arbiters hold no data and are left out.
+func (p MongodbProvider) mongodbMembers(headless *corev1.Service, endpoints []corev1.Endpoints) ([]string, error) {
+	var members []string
+	for _, host := range p.hostsFromEndpoints(headless, endpoints) {
+		pod := strings.SplitN(host, ".", 2)[0]
+		if !mongodbArbiterPod.MatchString(pod) {
+			members = append(members, host)
+		}
+	}
+	if len(members) == 0 {
+		return nil, errors.Errorf("no members found in the endpoints of service %s", headless.Name)
+	}
+	return members, nil
+}
+
 func (p MongodbProvider) Bind(
 	services []corev1.Service,
+	bindParams *BindParams,
+	provisionParams *ProvisionParams,
+	chartSecrets Object,
+) (Object, error) {
+	return p.BindWithEndpoints(services, nil, bindParams, provisionParams, chartSecrets)
+}
+
+// BindWithEndpoints returns the credentials of an instance, listing the members of a replica set
+// from the Endpoints of its headless service.
+func (p MongodbProvider) BindWithEndpoints(
+	services []corev1.Service,
+	endpoints []corev1.Endpoints,
 	_ *BindParams,
 	provisionParams *ProvisionParams,
 	chartSecrets Object,
 ) (Object, error) {
-	service := services[0]
-	if len(service.Spec.Ports) == 0 {
-		return nil, errors.Errorf("no ports found")
+	if len(services) == 0 {
+		return nil, errors.Errorf("no services to process")
 	}
-	svcPort := service.Spec.Ports[0]
+	classified := classifyMongodbServices(services)
 
-	host := p.hostFromService(&service)
-
-	database, err := provisionParams.DigStringOr("mongodbDatabase", "")
+	database, err := provisionParams.DigStringAltOr([]string{"auth.database", "mongodbDatabase"}, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database name: %w", err)
 	}
-	user, err := provisionParams.DigStringOr("mongodbUsername", rootMongodbUsername)
+	rootUser, err := provisionParams.DigStringOr("auth.rootUser", rootMongodbUsername)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the root username: %w", err)
+	}
+	user, err := provisionParams.DigStringAltOr([]string{"auth.username", "mongodbUsername"}, rootUser)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get username: %w", err)
 	}
 
 	var passwordKey string
-	if user == rootMongodbUsername {
+	// Users other than root are created in the database they are granted, root in the admin one.
+	authSource := mongodbAdminDatabase
+	if user == rootUser {
 		passwordKey = "mongodb-root-password"
 	} else {
 		passwordKey = "mongodb-password"
+		if database != "" {
+			authSource = database
+		}
 	}
 	password, err := chartSecrets.DigString(passwordKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get password: %w", err)
 	}
 
+	replicaSet, isReplicaSet, err := mongodbReplicaSetName(provisionParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the replica set name: %w", err)
+	}
+	topology := mongodbStandalone
+	switch {
+	case classified.mongos != nil:
+		topology = mongodbSharded
+	case isReplicaSet:
+		if classified.headless == nil {
+			return nil, errors.New("could not identify the headless service of the replica set")
+		}
+		topology = mongodbReplicaSet
+	}
+
+	// The service clients connect to: the mongos router of sharded clusters, or the primary service,
+	// if any, falling back to the first service.
+	service := classified.mongos
+	if service == nil {
+		service = classified.primary
+	}
+	if service == nil && topology == mongodbReplicaSet {
+		service = classified.headless
+	}
+	if service == nil {
+		service = &services[0]
+	}
+	svcPort, err := mongodbPort(service)
+	if err != nil {
+		return nil, err
+	}
+	host := p.hostFromService(service)
+	hosts := []string{fmt.Sprintf("%s:%d", host, svcPort.Port)}
+
+	query := url.Values{"authSource": {authSource}}
+	if topology == mongodbReplicaSet {
+		members, err := p.mongodbMembers(classified.headless, endpoints)
+		if err != nil {
+			return nil, err
+		}
+		memberPort, err := mongodbPort(classified.headless)
+		if err != nil {
+			return nil, err
+		}
+		hosts = make([]string, len(members))
+		for i, member := range members {
+			hosts[i] = fmt.Sprintf("%s:%d", member, memberPort.Port)
+		}
+		// Without a service in front of the replica set, clients connect to its first member.
+		if service == classified.headless {
+			host, svcPort = members[0], memberPort
+		}
+		query.Set("replicaSet", replicaSet)
+	}
+
 	creds := Object{
-		"protocol": mongodbProtocolName,
-		"port":     svcPort.Port,
-		"host":     host,
-		"username": user,
-		"password": password,
-		"database": database,
-		"uri": (&url.URL{
-			Scheme: mongodbProtocolName,
-			User:   url.UserPassword(user, password),
-			Host:   fmt.Sprintf("%s:%d", host, svcPort.Port),
-			Path:   database,
-		}).String(),
+		"protocol":    mongodbProtocolName,
+		"topology":    topology,
+		"port":        svcPort.Port,
+		"host":        host,
+		"hosts":       hosts,
+		"username":    user,
+		"password":    password,
+		"database":    database,
+		"auth_source": authSource,
+	}
+	tlsEnabled, _, err := mongodbTLS.addCredentials(creds, provisionParams, chartSecrets)
+	if err != nil {
+		return nil, err
+	}
+	if tlsEnabled {
+		query.Set("tls", "true")
+	}
+	creds["uri"] = (&url.URL{
+		Scheme:   mongodbProtocolName,
+		User:     url.UserPassword(user, password),
+		Host:     strings.Join(hosts, ","),
+		Path:     "/" + database,
+		RawQuery: query.Encode(),
+	}).String()
+
+	if topology == mongodbReplicaSet {
+		creds["replica_set"] = replicaSet
+		// Kubernetes publishes the SRV records of the named ports of headless services, e.g.
+		// _mongodb._tcp.<service>, which is what mongodb+srv URIs look up. TLS, enabled by default
+		// with mongodb+srv, is disabled unless the instance is served over TLS.
+		if _, ok := findServicePort(classified.headless, []string{mongodbProtocolName}); ok {
+			srvQuery := url.Values{"authSource": {authSource}, "replicaSet": {replicaSet}, "tls": {strconv.FormatBool(tlsEnabled)}}
+			creds["srv_uri"] = (&url.URL{
+				Scheme:   mongodbSRVProtocolName,
+				User:     url.UserPassword(user, password),
+				Host:     p.hostFromService(classified.headless),
+				Path:     "/" + database,
+				RawQuery: srvQuery.Encode(),
+			}).String()
+		}
 	}
 
 	return creds, nil
 }
+
+// ReferencedSecrets returns the existing secret holding the CA certificate, if any.
+func (p MongodbProvider) ReferencedSecrets(provisionParams *ProvisionParams) []string {
+	return mongodbTLS.referencedSecrets(provisionParams)
+}
+
+// Properties returns the Spring Boot properties of the credentials.
+func (p MongodbProvider) Properties(creds Object) (Object, error) {
+	return Object{"spring.data.mongodb.uri": creds["uri"]}, nil
+}
+
+// ValidateProvisionParams checks the parameters read when binding, including the keys used by older
+// chart versions, and that TLS doesn't require client certificates.
+func (p MongodbProvider) ValidateProvisionParams(provisionParams *ProvisionParams) []FieldError {
+	fieldErrors := validateAliasedStringParams(provisionParams, []string{"auth.database", "mongodbDatabase"})
+	fieldErrors = append(fieldErrors, validateAliasedStringParams(provisionParams, []string{"auth.username", "mongodbUsername"})...)
+	fieldErrors = append(fieldErrors, validateStringParams(provisionParams, "auth.rootUser", "replicaSetName", "replicaSet.name")...)
+	return append(fieldErrors, mongodbTLS.validateMutual(provisionParams)...)
+}
@@ -17,9 +17,13 @@ limitations under the License.
 package minibroker
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/url"
+	"strings"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -29,6 +33,13 @@ const (
 	rootMysqlUsername = "root"
 )
 
+// mysqlTLS are the TLS parameters of the mysql chart.
+var mysqlTLS = tlsParams{
+	enabled:        []string{"tls.enabled"},
+	existingSecret: []string{"tls.existingSecret"},
+	caCert:         bitnamiTLSCaCert,
+}
+
 type MySQLProvider struct {
 	hostBuilder
 }
@@ -39,13 +50,13 @@ func (p MySQLProvider) Bind(
 	provisionParams *ProvisionParams,
 	chartSecrets Object,
 ) (Object, error) {
-	service := services[0]
-	if len(service.Spec.Ports) == 0 {
-		return nil, errors.Errorf("no ports found")
+	service, replica, err := primaryAndReplicaServices(services)
+	if err != nil {
+		return nil, err
 	}
 	svcPort := service.Spec.Ports[0]
 
-	host := p.hostFromService(&service)
+	host := p.hostFromService(service)
 
 	database, err := provisionParams.DigStringOr("mysqlDatabase", "")
 	if err != nil {
@@ -67,6 +78,12 @@ func (p MySQLProvider) Bind(
 		return nil, fmt.Errorf("failed to get password: %w", err)
 	}
 
+	uri := &url.URL{
+		Scheme: mysqlProtocolName,
+		User:   url.UserPassword(user, password),
+		Host:   fmt.Sprintf("%s:%d", host, svcPort.Port),
+		Path:   database,
+	}
 	creds := Object{
 		"protocol": mysqlProtocolName,
 		"port":     svcPort.Port,
@@ -74,13 +91,177 @@ func (p MySQLProvider) Bind(
 		"username": user,
 		"password": password,
 		"database": database,
-		"uri": (&url.URL{
-			Scheme: mysqlProtocolName,
-			User:   url.UserPassword(user, password),
-			Host:   fmt.Sprintf("%s:%d", host, svcPort.Port),
-			Path:   database,
-		}).String(),
+	}
+	tlsEnabled, verify, err := mysqlTLS.addCredentials(creds, provisionParams, chartSecrets)
+	if err != nil {
+		return nil, err
+	}
+	if tlsEnabled {
+		uri.RawQuery = mysqlSSLMode(verify)
+	}
+	creds["uri"] = uri.String()
+	if replica != nil {
+		if err := addReadEndpoint(creds, p.hostFromService(replica), replica.Spec.Ports[0].Port); err != nil {
+			return nil, err
+		}
 	}
 
 	return creds, nil
 }
+
+// ValidateProvisionParams checks the parameters read when binding.
+func (p MySQLProvider) ValidateProvisionParams(provisionParams *ProvisionParams) []FieldError {
+	return validateStringParams(provisionParams, "mysqlDatabase", "mysqlUser")
+}
+
+// ReferencedSecrets returns the existing secret holding the TLS certificates, if any.
+func (p MySQLProvider) ReferencedSecrets(provisionParams *ProvisionParams) []string {
+	return mysqlTLS.referencedSecrets(provisionParams)
+}
+
+// JDBCURL returns the JDBC URL of the credentials, for MySQL Connector/J.
+func (p MySQLProvider) JDBCURL(creds Object) (string, error) {
+	return jdbcURL(mysqlProtocolName, creds, "sslMode", "VERIFY_IDENTITY", "REQUIRED")
+}
+
+// Properties returns the Spring Boot data source properties of the credentials.
+func (p MySQLProvider) Properties(creds Object) (Object, error) {
+	jdbc, err := p.JDBCURL(creds)
+	if err != nil {
+		return nil, err
+	}
+	return datasourceProperties(jdbc, creds), nil
+}
+
+// ValidateBindParams checks the parameters requesting a dedicated user.
+func (p MySQLProvider) ValidateBindParams(bindParams *BindParams) []FieldError {
+	return validateDedicatedUserParams(bindParams)
+}
+
+// OnBind creates the dedicated user requested by the binding parameters, if any, with privileges on
+// the database.
+func (p MySQLProvider) OnBind(binding *Binding, creds Object) (Object, error) {
+	return bindMySQLUser(binding, creds, rootMysqlUsername, "mysql-root-password")
+}
+
+// OnUnbind drops the dedicated user of the binding, if any.
+func (p MySQLProvider) OnUnbind(binding *Binding, creds Object) error {
+	return unbindMySQLUser(binding, creds, rootMysqlUsername, "mysql-root-password")
+}
+
+// bindMySQLUser creates the dedicated user requested by the binding parameters, if any, as the
+// admin user whose password is held by the adminPasswordKey of the chart secrets. It is shared by
+// the MySQL and MariaDB providers.
+func bindMySQLUser(binding *Binding, creds Object, adminUsername, adminPasswordKey string) (Object, error) {
+	access, ok := dedicatedUserAccess(binding.BindParams)
+	if !ok {
+		return creds, nil
+	}
+	database, _ := creds.DigStringOr("database", "")
+	if database == "" {
+		return nil, errors.New("a dedicated user requires a database to be provisioned")
+	}
+	dsn, err := mysqlAdminDSN(binding, creds, adminUsername, adminPasswordKey)
+	if err != nil {
+		return nil, err
+	}
+	username := bindingUsername(binding.ID)
+	password, err := generatePassword()
+	if err != nil {
+		return nil, err
+	}
+
+	user := mysqlQuoteLiteral(username) + "@'%'"
+	privileges := "ALL PRIVILEGES"
+	if access == readOnlyAccess {
+		privileges = "SELECT, SHOW VIEW"
+	}
+	create := []string{
+		fmt.Sprintf("CREATE USER %s IDENTIFIED BY %s", user, mysqlQuoteLiteral(password)),
+		fmt.Sprintf("GRANT %s ON %s.* TO %s", privileges, mysqlQuoteIdentifier(database), user),
+	}
+	if err := createDedicatedUser("mysql", dsn, create, dropMySQLUser(username)); err != nil {
+		return nil, err
+	}
+	return withUser(creds, username, password)
+}
+
+// unbindMySQLUser drops the dedicated user of the binding, if any.
+func unbindMySQLUser(binding *Binding, creds Object, adminUsername, adminPasswordKey string) error {
+	if _, ok := dedicatedUserAccess(binding.BindParams); !ok {
+		return nil
+	}
+	dsn, err := mysqlAdminDSN(binding, creds, adminUsername, adminPasswordKey)
+	if err != nil {
+		return err
+	}
+	if err := execStatements("mysql", dsn, dropMySQLUser(bindingUsername(binding.ID))); err != nil {
+		return fmt.Errorf("failed to drop the user of the binding: %v", err)
+	}
+	return nil
+}
+
+// dropMySQLUser returns the statements dropping a user, if it exists.
+func dropMySQLUser(username string) []string {
+	return []string{fmt.Sprintf("DROP USER IF EXISTS %s@'%%'", mysqlQuoteLiteral(username))}
+}
+
+// mysqlAdminDSN returns the data source name of the admin user.
+func mysqlAdminDSN(binding *Binding, creds Object, adminUsername, adminPasswordKey string) (string, error) {
+	host, err := creds.DigString("host")
+	if err != nil {
+		return "", fmt.Errorf("failed to get host: %w", err)
+	}
+	password, err := binding.ChartSecrets.DigString(adminPasswordKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the admin password: %w", err)
+	}
+	config := mysql.NewConfig()
+	config.User = adminUsername
+	config.Passwd = password
+	config.Net = "tcp"
+	config.Addr = fmt.Sprintf("%s:%v", host, creds["port"])
+	// The server of TLS instances is verified when the CA certificate is known.
+	if creds[tlsCredential] == true {
+		config.TLSConfig = "skip-verify"
+		if caCert, err := creds.DigString(caCertCredential); err == nil {
+			if config.TLSConfig, err = mysqlTLSConfig(caCert); err != nil {
+				return "", err
+			}
+		}
+	}
+	return config.FormatDSN(), nil
+}
+
+// mysqlTLSConfig registers the TLS configuration verifying the server with the CA certificate, and
+// returns its name.
+func mysqlTLSConfig(caCert string) (string, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(caCert)) {
+		return "", errors.New("failed to parse the CA certificate")
+	}
+	name := caCertName(caCert)
+	if err := mysql.RegisterTLSConfig(name, &tls.Config{RootCAs: roots}); err != nil {
+		return "", fmt.Errorf("failed to register the TLS configuration: %w", err)
+	}
+	return name, nil
+}
+
+// mysqlSSLMode returns the ssl-mode option of the URIs of an instance served over TLS, verifying the
+// server when the CA certificate is known.
+func mysqlSSLMode(verify bool) string {
+	if verify {
+		return "ssl-mode=VERIFY_IDENTITY"
+	}
+	return "ssl-mode=REQUIRED"
+}
+
+// mysqlQuoteLiteral quotes a string literal.
+func mysqlQuoteLiteral(literal string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(literal) + "'"
+}
+
+// mysqlQuoteIdentifier quotes an identifier, e.g. a database name.
+func mysqlQuoteIdentifier(identifier string) string {
+	return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+}
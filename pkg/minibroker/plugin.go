@@ -0,0 +1,165 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	klog "k8s.io/klog/v2"
+)
+
+// DefaultPluginTimeout is the default time a provider plugin has to produce the credentials.
+const DefaultPluginTimeout = 30 * time.Second
+
+// PluginRequest is the JSON document provider plugins read from their standard input.
+type PluginRequest struct {
+	Services        []corev1.Service       `json:"services"`
+	BindParams      map[string]interface{} `json:"bindParams"`
+	ProvisionParams map[string]interface{} `json:"provisionParams"`
+	ChartSecrets    map[string]interface{} `json:"chartSecrets"`
+	// The cluster domain the hosts of the services are built with.
+	ClusterDomain string `json:"clusterDomain"`
+}
+
+// PluginResponse is the JSON document provider plugins write to their standard output. Plugins
+// failing to produce the credentials set Error, or exit with a non-zero status, writing the
+// reason to their standard error.
+type PluginResponse struct {
+	Credentials map[string]interface{} `json:"credentials,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// PluginProvider is the Provider running an external executable to produce the credentials.
+type PluginProvider struct {
+	hostBuilder
+	name    string
+	path    string
+	timeout time.Duration
+}
+
+// LoadProviderPlugins discovers the provider plugins in dir: every executable file is a plugin
+// for the chart named after the file, without its extension, e.g. memcached or memcached.py.
+func LoadProviderPlugins(dir string, timeout time.Duration) (map[string]*PluginProvider, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load provider plugins: %v", err)
+	}
+	plugins := make(map[string]*PluginProvider)
+	for _, file := range files {
+		if !file.Mode().IsRegular() || file.Mode().Perm()&0111 == 0 {
+			klog.V(4).Infof("minibroker: skipping %q in the provider plugin directory: not an executable file", file.Name())
+			continue
+		}
+		name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		if existing, ok := plugins[name]; ok {
+			return nil, fmt.Errorf("failed to load provider plugins: %q and %q are plugins for chart %q", filepath.Base(existing.path), file.Name(), name)
+		}
+		plugins[name] = &PluginProvider{
+			name:    name,
+			path:    filepath.Join(dir, file.Name()),
+			timeout: timeout,
+		}
+		klog.V(3).Infof("minibroker: loaded the provider plugin for chart %q", name)
+	}
+	return plugins, nil
+}
+
+func (p PluginProvider) Bind(
+	services []corev1.Service,
+	bindParams *BindParams,
+	provisionParams *ProvisionParams,
+	chartSecrets Object,
+) (Object, error) {
+	request := PluginRequest{
+		Services:      services,
+		ChartSecrets:  chartSecrets,
+		ClusterDomain: p.clusterDomain,
+	}
+	if bindParams != nil {
+		request.BindParams = bindParams.Object
+	}
+	if provisionParams != nil {
+		request.ProvisionParams = provisionParams.Object
+	}
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the request of provider plugin %q: %v", p.name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	stdout, stderr, err := runPlugin(ctx, p.path, input)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, &OperationError{
+			Kind:       ErrorKindProvider,
+			StatusCode: http.StatusGatewayTimeout,
+			Err:        fmt.Errorf("provider plugin %q timed out after %v", p.name, p.timeout),
+		}
+	}
+	if err != nil {
+		if reason := strings.TrimSpace(string(stderr)); reason != "" {
+			return nil, fmt.Errorf("provider plugin %q failed: %v: %s", p.name, err, reason)
+		}
+		return nil, fmt.Errorf("provider plugin %q failed: %v", p.name, err)
+	}
+
+	var response PluginResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return nil, fmt.Errorf("provider plugin %q returned an invalid response: %v", p.name, err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("provider plugin %q failed: %s", p.name, response.Error)
+	}
+	return Object(response.Credentials), nil
+}
+
+// runPlugin runs the plugin at path with input as its standard input, until it exits or the
+// context is done. The plugin runs in its own process group, which is killed as a whole when the
+// context is done, so that processes it leaves behind can't hold its output open past its timeout.
+// The output, which carries credentials, is kept in memory.
+func runPlugin(ctx context.Context, path string, input []byte) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return stdout.Bytes(), stderr.Bytes(), err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		return nil, nil, ctx.Err()
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// writePlugin writes a shell script plugin to dir.
+func writePlugin(t *testing.T, dir, name, script string, executable bool) {
+	t.Helper()
+	mode := 0644
+	if executable {
+		mode = 0755
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), os.FileMode(mode)); err != nil {
+		t.Fatalf("failed to write plugin %q: %v", name, err)
+	}
+}
+
+func TestLoadProviderPlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minibroker-plugins-")
+	if err != nil {
+		t.Fatalf("failed to create the plugin directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writePlugin(t, dir, "memcached", "", true)
+	writePlugin(t, dir, "couchdb.sh", "", true)
+	writePlugin(t, dir, "README", "", false)
+
+	plugins, err := LoadProviderPlugins(dir, time.Second)
+	if err != nil {
+		t.Fatalf("LoadProviderPlugins: unexpected error: %v", err)
+	}
+	var charts []string
+	for chart := range plugins {
+		charts = append(charts, chart)
+	}
+	sort.Strings(charts)
+	if expected := []string{"couchdb", "memcached"}; !reflect.DeepEqual(charts, expected) {
+		t.Errorf("LoadProviderPlugins: expected the plugins %v, actual %v", expected, charts)
+	}
+
+	writePlugin(t, dir, "memcached.py", "", true)
+	expected := `failed to load provider plugins: "memcached" and "memcached.py" are plugins for chart "memcached"`
+	if _, err := LoadProviderPlugins(dir, time.Second); err == nil || err.Error() != expected {
+		t.Errorf("LoadProviderPlugins: expected error %q, actual %v", expected, err)
+	}
+}
+
+func TestPluginProviderBind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minibroker-plugins-")
+	if err != nil {
+		t.Fatalf("failed to create the plugin directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// The plugin echoes the request fields it was given as credentials.
+	writePlugin(t, dir, "echo", `
+input=$(cat)
+case "$input" in
+  *'"name":"db"'*'"bindParams":{"user":"app"}'*'"provisionParams":{"database":"shop"}'*'"chartSecrets":{"password":"pass"}'*'"clusterDomain":"cluster.local"'*)
+    echo '{"credentials": {"host": "db.ns.svc.cluster.local", "port": 11211}}' ;;
+  *)
+    echo "unexpected request: $input" >&2; exit 1 ;;
+esac
+`, true)
+
+	plugins, err := LoadProviderPlugins(dir, time.Minute)
+	if err != nil {
+		t.Fatalf("LoadProviderPlugins: unexpected error: %v", err)
+	}
+	client := &Client{providers: map[string]Provider{}}
+	client.registerProviderPlugins(hostBuilder{"cluster.local"}, plugins)
+
+	services := []corev1.Service{{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "ns"}}}
+	creds, err := client.providers["echo"].Bind(
+		services,
+		NewBindParams(map[string]interface{}{"user": "app"}),
+		NewProvisionParams(map[string]interface{}{"database": "shop"}),
+		Object{"password": "pass"},
+	)
+	if err != nil {
+		t.Fatalf("Bind: unexpected error: %v", err)
+	}
+	expected := Object{"host": "db.ns.svc.cluster.local", "port": float64(11211)}
+	if !reflect.DeepEqual(creds, expected) {
+		t.Errorf("Bind: expected %v, actual %v", expected, creds)
+	}
+}
+
+func TestPluginProviderBindErrors(t *testing.T) {
+	tests := []struct {
+		script     string
+		timeout    time.Duration
+		expected   string
+		statusCode int
+	}{
+		{`echo '{"error": "no service"}'`, time.Minute, `provider plugin "plugin" failed: no service`, http.StatusInternalServerError},
+		{"echo 'cannot connect' >&2; exit 3", time.Minute, `provider plugin "plugin" failed: exit status 3: cannot connect`, http.StatusInternalServerError},
+		{"exit 3", time.Minute, `provider plugin "plugin" failed: exit status 3`, http.StatusInternalServerError},
+		{"echo 'credentials'", time.Minute, `provider plugin "plugin" returned an invalid response: `, http.StatusInternalServerError},
+		// The background sleep inherits the plugin output, and must be killed along with it.
+		{"sleep 60 & wait", 100 * time.Millisecond, `provider plugin "plugin" timed out after 100ms`, http.StatusGatewayTimeout},
+	}
+
+	dir, err := ioutil.TempDir("", "minibroker-plugins-")
+	if err != nil {
+		t.Fatalf("failed to create the plugin directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range tests {
+		writePlugin(t, dir, "plugin", tt.script, true)
+		provider := PluginProvider{name: "plugin", path: filepath.Join(dir, "plugin"), timeout: tt.timeout}
+
+		_, err := provider.Bind(nil, nil, nil, Object{})
+		if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("Bind(%q): expected error starting with %q, actual %v", tt.script, tt.expected, err)
+			continue
+		}
+		var opErr *OperationError
+		if !errors.As(newProviderError(err), &opErr) || opErr.StatusCode != tt.statusCode {
+			t.Errorf("Bind(%q): expected status code %d, actual %v", tt.script, tt.statusCode, opErr)
+		}
+	}
+}
@@ -18,9 +18,12 @@ package minibroker
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
 
-	"github.com/pkg/errors"
+	"github.com/lib/pq"
 	corev1 "k8s.io/api/core/v1"
 )
 
@@ -29,6 +32,17 @@ const (
 	defaultPostgresqlUsername = "postgres"
 )
 
+// postgresTLS are the TLS parameters of the postgresql chart.
+var postgresTLS = tlsParams{
+	enabled:        []string{"tls.enabled"},
+	existingSecret: []string{"tls.certificatesSecret"},
+	caCert:         bitnamiTLSCaCert,
+}
+
+// postgresCACertDir is the directory the CA certificates of the admin connections are written to, as
+// lib/pq reads them from files.
+var postgresCACertDir = os.TempDir()
+
 type PostgresProvider struct {
 	hostBuilder
 }
@@ -39,13 +53,13 @@ func (p PostgresProvider) Bind(
 	provisionParams *ProvisionParams,
 	chartSecrets Object,
 ) (Object, error) {
-	service := services[0]
-	if len(service.Spec.Ports) == 0 {
-		return nil, errors.Errorf("no ports found")
+	service, replica, err := primaryAndReplicaServices(services)
+	if err != nil {
+		return nil, err
 	}
 	svcPort := service.Spec.Ports[0]
 
-	host := p.hostFromService(&service)
+	host := p.hostFromService(service)
 
 	database, err := provisionParams.DigStringAltOr(
 		// Some older chart versions use postgresDatabase instead of postgresqlDatabase.
@@ -80,6 +94,12 @@ func (p PostgresProvider) Bind(
 		return nil, fmt.Errorf("failed to get password: %w", err)
 	}
 
+	uri := &url.URL{
+		Scheme: postgresqlProtocolName,
+		User:   url.UserPassword(user, password),
+		Host:   fmt.Sprintf("%s:%d", host, svcPort.Port),
+		Path:   database,
+	}
 	creds := Object{
 		"protocol": postgresqlProtocolName,
 		"port":     svcPort.Port,
@@ -87,13 +107,210 @@ func (p PostgresProvider) Bind(
 		"username": user,
 		"password": password,
 		"database": database,
-		"uri": (&url.URL{
-			Scheme: postgresqlProtocolName,
-			User:   url.UserPassword(user, password),
-			Host:   fmt.Sprintf("%s:%d", host, svcPort.Port),
-			Path:   database,
-		}).String(),
+	}
+	tlsEnabled, verify, err := postgresTLS.addCredentials(creds, provisionParams, chartSecrets)
+	if err != nil {
+		return nil, err
+	}
+	if tlsEnabled {
+		uri.RawQuery = postgresSSLMode(verify)
+	}
+	creds["uri"] = uri.String()
+	if replica != nil {
+		if err := addReadEndpoint(creds, p.hostFromService(replica), replica.Spec.Ports[0].Port); err != nil {
+			return nil, err
+		}
 	}
 
 	return creds, nil
 }
+
+// ValidateProvisionParams checks the parameters read when binding, including the keys used by older
+// chart versions.
+func (p PostgresProvider) ValidateProvisionParams(provisionParams *ProvisionParams) []FieldError {
+	fieldErrors := validateAliasedStringParams(provisionParams, []string{"postgresqlDatabase", "postgresDatabase"})
+	return append(fieldErrors, validateAliasedStringParams(provisionParams, []string{"postgresqlUsername", "postgresUsername"})...)
+}
+
+// ReferencedSecrets returns the existing secret holding the TLS certificates, if any.
+func (p PostgresProvider) ReferencedSecrets(provisionParams *ProvisionParams) []string {
+	return postgresTLS.referencedSecrets(provisionParams)
+}
+
+// JDBCURL returns the JDBC URL of the credentials.
+func (p PostgresProvider) JDBCURL(creds Object) (string, error) {
+	return jdbcURL(postgresqlProtocolName, creds, "sslmode", "verify-full", "require")
+}
+
+// Properties returns the Spring Boot data source properties of the credentials.
+func (p PostgresProvider) Properties(creds Object) (Object, error) {
+	jdbc, err := p.JDBCURL(creds)
+	if err != nil {
+		return nil, err
+	}
+	return datasourceProperties(jdbc, creds), nil
+}
+
+// ValidateBindParams checks the parameters requesting a dedicated user.
+func (p PostgresProvider) ValidateBindParams(bindParams *BindParams) []FieldError {
+	return validateDedicatedUserParams(bindParams)
+}
+
+// OnBind creates the dedicated user requested by the binding parameters, if any, with privileges on
+// the public schema of the database.
+func (p PostgresProvider) OnBind(binding *Binding, creds Object) (Object, error) {
+	access, ok := dedicatedUserAccess(binding.BindParams)
+	if !ok {
+		return creds, nil
+	}
+	dsn, database, err := p.adminDSN(binding)
+	if err != nil {
+		return nil, err
+	}
+	username := bindingUsername(binding.ID)
+	password, err := generatePassword()
+	if err != nil {
+		return nil, err
+	}
+
+	user := pq.QuoteIdentifier(username)
+	create := []string{
+		fmt.Sprintf("CREATE ROLE %s WITH LOGIN PASSWORD %s", user, pq.QuoteLiteral(password)),
+	}
+	if access == readOnlyAccess {
+		create = append(create,
+			fmt.Sprintf("GRANT CONNECT ON DATABASE %s TO %s", pq.QuoteIdentifier(database), user),
+			fmt.Sprintf("GRANT USAGE ON SCHEMA public TO %s", user),
+			fmt.Sprintf("GRANT SELECT ON ALL TABLES IN SCHEMA public TO %s", user),
+			fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT ON TABLES TO %s", user),
+		)
+	} else {
+		create = append(create,
+			fmt.Sprintf("GRANT CONNECT, TEMPORARY ON DATABASE %s TO %s", pq.QuoteIdentifier(database), user),
+			fmt.Sprintf("GRANT USAGE, CREATE ON SCHEMA public TO %s", user),
+			fmt.Sprintf("GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA public TO %s", user),
+			fmt.Sprintf("GRANT ALL PRIVILEGES ON ALL SEQUENCES IN SCHEMA public TO %s", user),
+			fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT ALL ON TABLES TO %s", user),
+			fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT ALL ON SEQUENCES TO %s", user),
+		)
+	}
+	if err := createDedicatedUser("postgres", dsn, create, dropPostgresUser(username)); err != nil {
+		return nil, err
+	}
+	return withUser(creds, username, password)
+}
+
+// OnUnbind drops the dedicated user of the binding, if any. The objects it owns are handed over to
+// the admin user.
+func (p PostgresProvider) OnUnbind(binding *Binding, creds Object) error {
+	if _, ok := dedicatedUserAccess(binding.BindParams); !ok {
+		return nil
+	}
+	dsn, _, err := p.adminDSN(binding)
+	if err != nil {
+		return err
+	}
+	if err := execStatements("postgres", dsn, dropPostgresUser(bindingUsername(binding.ID))); err != nil {
+		return fmt.Errorf("failed to drop the user of the binding: %v", err)
+	}
+	return nil
+}
+
+// dropPostgresUser returns the statements dropping a user, if it exists.
+func dropPostgresUser(username string) []string {
+	user := pq.QuoteIdentifier(username)
+	return []string{fmt.Sprintf(
+		"DO $$ BEGIN IF EXISTS (SELECT FROM pg_roles WHERE rolname = %s) THEN "+
+			"EXECUTE %s; EXECUTE %s; EXECUTE %s; END IF; END $$",
+		pq.QuoteLiteral(username),
+		pq.QuoteLiteral("REASSIGN OWNED BY "+user+" TO CURRENT_USER"),
+		pq.QuoteLiteral("DROP OWNED BY "+user),
+		pq.QuoteLiteral("DROP ROLE "+user),
+	)}
+}
+
+// adminDSN returns the connection string of the admin user, i.e. the postgres superuser when the
+// chart sets its password apart, and the database of the binding. The admin credentials are built
+// from the chart secrets and provision parameters, as the credentials of a binding are the ones of
+// its dedicated user.
+func (p PostgresProvider) adminDSN(binding *Binding) (string, string, error) {
+	creds, err := p.Bind(binding.Services, nil, binding.ProvisionParams, binding.ChartSecrets)
+	if err != nil {
+		return "", "", err
+	}
+	host, err := creds.DigString("host")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get host: %w", err)
+	}
+	username, err := creds.DigString("username")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get username: %w", err)
+	}
+	password, err := creds.DigString("password")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get password: %w", err)
+	}
+	if superuserPassword, err := binding.ChartSecrets.DigString("postgresql-postgres-password"); err == nil {
+		username, password = defaultPostgresqlUsername, superuserPassword
+	}
+	database, _ := creds.DigStringOr("database", "")
+	if database == "" {
+		database = defaultPostgresqlUsername
+	}
+	// The admin connection is encrypted when the instance is served over TLS, verifying the server
+	// when the CA certificate is known.
+	query := url.Values{"sslmode": {"disable"}}
+	if creds[tlsCredential] == true {
+		query.Set("sslmode", "require")
+		if caCert, err := creds.DigString(caCertCredential); err == nil {
+			file, err := postgresCACertFile(caCert)
+			if err != nil {
+				return "", "", err
+			}
+			query.Set("sslmode", "verify-full")
+			query.Set("sslrootcert", file)
+		}
+	}
+	dsn := &url.URL{
+		Scheme:   postgresqlProtocolName,
+		User:     url.UserPassword(username, password),
+		Host:     fmt.Sprintf("%s:%v", host, creds["port"]),
+		Path:     database,
+		RawQuery: query.Encode(),
+	}
+	return dsn.String(), database, nil
+}
+
+// postgresCACertFile returns the file holding the CA certificate, writing it unless an earlier
+// connection did. The file is renamed into place once written, so that it is never read partially.
+func postgresCACertFile(caCert string) (string, error) {
+	path := filepath.Join(postgresCACertDir, caCertName(caCert)+".crt")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	file, err := ioutil.TempFile(postgresCACertDir, caCertName(caCert)+"-")
+	if err != nil {
+		return "", fmt.Errorf("failed to write the CA certificate: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(caCert); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to write the CA certificate: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write the CA certificate: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to write the CA certificate: %w", err)
+	}
+	return path, nil
+}
+
+// postgresSSLMode returns the sslmode option of the URIs of an instance served over TLS, verifying
+// the server when the CA certificate is known.
+func postgresSSLMode(verify bool) string {
+	if verify {
+		return "sslmode=verify-full"
+	}
+	return "sslmode=require"
+}
@@ -18,6 +18,7 @@ package minibroker
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
@@ -33,6 +34,18 @@ type Provider interface {
 	) (Object, error)
 }
 
+// EndpointsBinder is implemented by the Providers listing the members of an instance, e.g. the nodes
+// of a Redis Cluster, from the Endpoints of its headless services. It is called instead of Bind.
+type EndpointsBinder interface {
+	BindWithEndpoints(
+		services []corev1.Service,
+		endpoints []corev1.Endpoints,
+		bindParams *BindParams,
+		provisionParams *ProvisionParams,
+		chartSecrets Object,
+	) (Object, error)
+}
+
 // Object is a wrapper around map[string]interface{} that implements methods for helping with
 // digging and type asserting.
 type Object map[string]interface{}
@@ -159,3 +172,27 @@ type hostBuilder struct {
 func (hb *hostBuilder) hostFromService(service *corev1.Service) string {
 	return fmt.Sprintf("%s.%s.svc.%s", service.Name, service.Namespace, hb.clusterDomain)
 }
+
+// hostsFromEndpoints builds the sorted FQDNs of the pods backing a headless service, as listed by
+// its Endpoints, ready or not. Pods without a hostname are left out, as only the pods of a
+// StatefulSet get a stable DNS name.
+func (hb *hostBuilder) hostsFromEndpoints(service *corev1.Service, endpoints []corev1.Endpoints) []string {
+	domain := hb.hostFromService(service)
+	var hosts []string
+	for _, serviceEndpoints := range endpoints {
+		if serviceEndpoints.Name != service.Name || serviceEndpoints.Namespace != service.Namespace {
+			continue
+		}
+		for _, subset := range serviceEndpoints.Subsets {
+			for _, addresses := range [][]corev1.EndpointAddress{subset.Addresses, subset.NotReadyAddresses} {
+				for _, address := range addresses {
+					if address.Hostname != "" {
+						hosts = append(hosts, address.Hostname+"."+domain)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
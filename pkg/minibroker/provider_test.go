@@ -16,7 +16,52 @@ limitations under the License.
 
 package minibroker
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testService returns a service of the ns namespace. Headless services have no cluster IP.
+func testService(name string, headless bool, selector, labels, annotations map[string]string, ports ...corev1.ServicePort) corev1.Service {
+	svc := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: labels, Annotations: annotations},
+		Spec:       corev1.ServiceSpec{Selector: selector, Ports: ports},
+	}
+	if headless {
+		svc.Spec.ClusterIP = corev1.ClusterIPNone
+	}
+	return svc
+}
+
+// testSecret returns a secret of the ns namespace.
+func testSecret(name string, labels, annotations, data map[string]string) corev1.Secret {
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: labels, Annotations: annotations},
+		Data:       make(map[string][]byte),
+	}
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+	return secret
+}
+
+// testEndpoints returns the Endpoints of a service in the ns namespace, listing pods by hostname.
+func testEndpoints(service string, ready, notReady []string) corev1.Endpoints {
+	addresses := func(hostnames []string) []corev1.EndpointAddress {
+		var addresses []corev1.EndpointAddress
+		for _, hostname := range hostnames {
+			addresses = append(addresses, corev1.EndpointAddress{IP: "10.0.0.1", Hostname: hostname})
+		}
+		return addresses
+	}
+	return corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: service, Namespace: "ns"},
+		Subsets:    []corev1.EndpointSubset{{Addresses: addresses(ready), NotReadyAddresses: addresses(notReady)}},
+	}
+}
 
 func TestObjectDig(t *testing.T) {
 	tests := []struct {
@@ -263,3 +308,23 @@ func TestObjectDigStringAltOr(t *testing.T) {
 		}
 	}
 }
+
+func TestHostsFromEndpoints(t *testing.T) {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "db-headless", Namespace: "ns"}}
+	endpoints := []corev1.Endpoints{
+		testEndpoints("db-headless", []string{"db-2", "db-0"}, []string{"db-1"}),
+		testEndpoints("other-headless", []string{"other-0"}, nil),
+	}
+	// The pods of a Deployment have no hostname.
+	endpoints[0].Subsets[0].Addresses = append(endpoints[0].Subsets[0].Addresses, corev1.EndpointAddress{IP: "10.0.0.2"})
+
+	hosts := (&hostBuilder{"cluster.local"}).hostsFromEndpoints(service, endpoints)
+	expected := []string{
+		"db-0.db-headless.ns.svc.cluster.local",
+		"db-1.db-headless.ns.svc.cluster.local",
+		"db-2.db-headless.ns.svc.cluster.local",
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("hostsFromEndpoints: expected %v, actual %v", expected, hosts)
+	}
+}
@@ -17,8 +17,15 @@ limitations under the License.
 package minibroker
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
@@ -26,9 +33,40 @@ import (
 
 const (
 	amqpProtocolName        = "amqp"
+	amqpsProtocolName       = "amqps"
 	defaultRabbitmqUsername = "user"
+	defaultRabbitmqVhost    = "/"
+
+	rabbitmqManagementRole = "management"
+	// The binding parameter requesting a dedicated virtual host, along with a dedicated user.
+	dedicatedVhostParam = "dedicatedVhost"
 )
 
+// rabbitmqPortRoles maps the names of the ports of the RabbitMQ services, across chart versions, to
+// the names listed in the credentials.
+var rabbitmqPortRoles = map[string]string{
+	"amqp":       amqpProtocolName,
+	"amqps":      amqpsProtocolName,
+	"amqp-ssl":   amqpsProtocolName,
+	"amqp-tls":   amqpsProtocolName,
+	"stats":      rabbitmqManagementRole,
+	"http-stats": rabbitmqManagementRole,
+	"management": rabbitmqManagementRole,
+}
+
+// rabbitmqHTTPClient is the client of the management API.
+var rabbitmqHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// rabbitmqTLS are the TLS parameters of the rabbitmq chart, across chart versions. Provisioning
+// requires failIfNoPeerCert to be disabled, as clients otherwise present a certificate.
+var rabbitmqTLS = tlsParams{
+	enabled:         []string{"auth.tls.enabled", "rabbitmq.tls.enabled"},
+	existingSecret:  []string{"auth.tls.existingSecret", "rabbitmq.tls.existingSecret"},
+	caCert:          secretKeyParam{defaultKey: "ca.crt"},
+	mutual:          []string{"auth.tls.failIfNoPeerCert", "rabbitmq.tls.failIfNoPeerCert"},
+	mutualByDefault: true,
+}
+
 type RabbitmqProvider struct {
 	hostBuilder
 }
@@ -67,17 +105,267 @@ func (p RabbitmqProvider) Bind(
 
 	host := p.hostFromService(&service)
 	creds := Object{
-		"protocol": amqpProtocolName,
-		"port":     svcPort.Port,
-		"host":     host,
 		"username": user,
 		"password": password,
-		"uri": (&url.URL{
-			Scheme: amqpProtocolName,
-			User:   url.UserPassword(user, password),
-			Host:   fmt.Sprintf("%s:%d", host, svcPort.Port),
-		}).String(),
+	}
+
+	ports := Object{}
+	for _, port := range service.Spec.Ports {
+		if role, ok := rabbitmqPortRoles[port.Name]; ok {
+			ports[role] = port.Port
+		}
+	}
+	creds["ports"] = ports
+
+	// Clients connect to the amqps port of instances served over TLS.
+	scheme, port := amqpProtocolName, svcPort.Port
+	tlsEnabled, _, err := rabbitmqTLS.addCredentials(creds, provisionParams, chartSecrets)
+	if err != nil {
+		return nil, err
+	}
+	if tlsEnabled {
+		amqpsPort, ok := ports[amqpsProtocolName]
+		if !ok {
+			return nil, errors.Errorf("no amqps port found")
+		}
+		scheme, port = amqpsProtocolName, amqpsPort.(int32)
+	}
+	creds["protocol"] = scheme
+	creds["host"] = host
+	creds["port"] = port
+	creds["uri"] = (&url.URL{
+		Scheme: scheme,
+		User:   url.UserPassword(user, password),
+		Host:   fmt.Sprintf("%s:%d", host, port),
+	}).String()
+	if managementPort, ok := ports[rabbitmqManagementRole]; ok {
+		creds["management_url"] = (&url.URL{
+			Scheme: "http",
+			Host:   fmt.Sprintf("%s:%d", host, managementPort),
+		}).String()
 	}
 
 	return creds, nil
 }
+
+// ReferencedSecrets returns the existing secret holding the TLS certificates, if any.
+func (p RabbitmqProvider) ReferencedSecrets(provisionParams *ProvisionParams) []string {
+	return rabbitmqTLS.referencedSecrets(provisionParams)
+}
+
+// Properties returns the Spring Boot properties of the credentials.
+func (p RabbitmqProvider) Properties(creds Object) (Object, error) {
+	properties := Object{
+		"spring.rabbitmq.host":     creds["host"],
+		"spring.rabbitmq.port":     creds["port"],
+		"spring.rabbitmq.username": creds["username"],
+		"spring.rabbitmq.password": creds["password"],
+	}
+	if vhost, ok := creds["vhost"]; ok {
+		properties["spring.rabbitmq.virtual-host"] = vhost
+	}
+	if creds[tlsCredential] == true {
+		properties["spring.rabbitmq.ssl.enabled"] = true
+	}
+	return properties, nil
+}
+
+// ValidateProvisionParams checks the parameters read when binding, and that TLS doesn't require
+// client certificates.
+func (p RabbitmqProvider) ValidateProvisionParams(provisionParams *ProvisionParams) []FieldError {
+	fieldErrors := validateStringParams(provisionParams, "rabbitmq.username")
+	return append(fieldErrors, rabbitmqTLS.validateMutual(provisionParams)...)
+}
+
+// ValidateBindParams checks the parameters requesting a dedicated user and virtual host.
+func (p RabbitmqProvider) ValidateBindParams(bindParams *BindParams) []FieldError {
+	fieldErrors := validateDedicatedUserParams(bindParams)
+	if vhost, ok := bindParams.Dig(dedicatedVhostParam); ok {
+		if _, isBool := vhost.(bool); !isBool {
+			fieldErrors = append(fieldErrors, FieldError{Field: dedicatedVhostParam, Description: "must be a boolean"})
+		} else if _, dedicated := dedicatedUserAccess(bindParams); vhost == true && !dedicated {
+			fieldErrors = append(fieldErrors, FieldError{Field: dedicatedVhostParam, Description: fmt.Sprintf("requires %s", dedicatedUserParam)})
+		}
+	}
+	return fieldErrors
+}
+
+// OnBind creates the dedicated user, and virtual host, requested by the binding parameters, if any,
+// through the management API.
+func (p RabbitmqProvider) OnBind(binding *Binding, creds Object) (Object, error) {
+	access, ok := dedicatedUserAccess(binding.BindParams)
+	if !ok {
+		return creds, nil
+	}
+	api, err := newRabbitmqManagementAPI(binding, creds)
+	if err != nil {
+		return nil, err
+	}
+	username := bindingUsername(binding.ID)
+	password, err := generatePassword()
+	if err != nil {
+		return nil, err
+	}
+	vhost := rabbitmqVhost(binding)
+
+	err = func() error {
+		if vhost != defaultRabbitmqVhost {
+			if err := api.do(http.MethodPut, "/api/vhosts/"+url.PathEscape(vhost), nil); err != nil {
+				return err
+			}
+		}
+		user := map[string]string{"password": password, "tags": ""}
+		if err := api.do(http.MethodPut, "/api/users/"+url.PathEscape(username), user); err != nil {
+			return err
+		}
+		permissions := map[string]string{"configure": ".*", "write": ".*", "read": ".*"}
+		if access == readOnlyAccess {
+			// Consuming from an exchange requires a queue: read-only users can only configure and
+			// write to the queues, and exchanges, named with the prefix of the binding.
+			prefix := "^" + regexp.QuoteMeta(rabbitmqQueuePrefix(username)) + ".*"
+			permissions["configure"], permissions["write"] = prefix, prefix
+		}
+		return api.do(http.MethodPut, "/api/permissions/"+url.PathEscape(vhost)+"/"+url.PathEscape(username), permissions)
+	}()
+	if err != nil {
+		if deleteErr := api.deleteBinding(username, vhost); deleteErr != nil {
+			return nil, fmt.Errorf("failed to create the user of the binding: %v (the user could not be deleted: %v)", err, deleteErr)
+		}
+		return nil, fmt.Errorf("failed to create the user of the binding: %v", err)
+	}
+
+	userCreds, err := withUser(creds, username, password)
+	if err != nil {
+		return nil, err
+	}
+	userCreds["vhost"] = vhost
+	if access == readOnlyAccess {
+		userCreds["queue_prefix"] = rabbitmqQueuePrefix(username)
+	}
+	if vhost != defaultRabbitmqVhost {
+		rawURI, err := userCreds.DigString("uri")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the uri: %w", err)
+		}
+		uri, err := url.Parse(rawURI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the uri: %v", err)
+		}
+		uri.Path = "/" + vhost
+		userCreds["uri"] = uri.String()
+	}
+	return userCreds, nil
+}
+
+// OnUnbind deletes the dedicated user, and virtual host, of the binding, if any.
+func (p RabbitmqProvider) OnUnbind(binding *Binding, creds Object) error {
+	if _, ok := dedicatedUserAccess(binding.BindParams); !ok {
+		return nil
+	}
+	api, err := newRabbitmqManagementAPI(binding, creds)
+	if err != nil {
+		return err
+	}
+	if err := api.deleteBinding(bindingUsername(binding.ID), rabbitmqVhost(binding)); err != nil {
+		return fmt.Errorf("failed to delete the user of the binding: %v", err)
+	}
+	return nil
+}
+
+// rabbitmqVhost returns the virtual host of a binding: the default one, or its dedicated one.
+func rabbitmqVhost(binding *Binding) string {
+	if vhost, _ := binding.BindParams.Dig(dedicatedVhostParam); vhost == true {
+		return bindingUsername(binding.ID)
+	}
+	return defaultRabbitmqVhost
+}
+
+// rabbitmqQueuePrefix returns the prefix of the names of the queues a read-only user can declare.
+func rabbitmqQueuePrefix(username string) string {
+	return username + "."
+}
+
+// rabbitmqManagementAPI is a client of the RabbitMQ management API, authenticated as the admin user
+// of the chart.
+type rabbitmqManagementAPI struct {
+	url      string
+	username string
+	password string
+}
+
+// newRabbitmqManagementAPI returns the client of the management API of the instance of a binding,
+// given its credentials.
+func newRabbitmqManagementAPI(binding *Binding, creds Object) (*rabbitmqManagementAPI, error) {
+	managementURL, err := creds.DigString("management_url")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the management url: %w", err)
+	}
+	username, err := binding.ProvisionParams.DigStringOr("rabbitmq.username", defaultRabbitmqUsername)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the admin username: %w", err)
+	}
+	password, err := binding.ChartSecrets.DigString("rabbitmq-password")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the admin password: %w", err)
+	}
+	return &rabbitmqManagementAPI{url: managementURL, username: username, password: password}, nil
+}
+
+// do sends a request to the management API, with body encoded as JSON if set.
+func (a *rabbitmqManagementAPI) do(method, path string, body interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	request, err := http.NewRequest(method, a.url+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.SetBasicAuth(a.username, a.password)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := rabbitmqHTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		message, _ := ioutil.ReadAll(response.Body)
+		return &rabbitmqAPIError{method: method, path: path, statusCode: response.StatusCode, message: strings.TrimSpace(string(message))}
+	}
+	return nil
+}
+
+// deleteBinding deletes the user, and the virtual host unless it is the default one. Missing ones
+// are ignored, so that unbinding can be retried.
+func (a *rabbitmqManagementAPI) deleteBinding(username, vhost string) error {
+	paths := []string{"/api/users/" + url.PathEscape(username)}
+	if vhost != defaultRabbitmqVhost {
+		paths = append(paths, "/api/vhosts/"+url.PathEscape(vhost))
+	}
+	for _, path := range paths {
+		err := a.do(http.MethodDelete, path, nil)
+		if apiErr, ok := err.(*rabbitmqAPIError); ok && apiErr.statusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rabbitmqAPIError is an error response of the management API.
+type rabbitmqAPIError struct {
+	method     string
+	path       string
+	statusCode int
+	message    string
+}
+
+// Error satisfies the error interface.
+func (e *rabbitmqAPIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.method, e.path, e.statusCode, e.message)
+}
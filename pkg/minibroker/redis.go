@@ -19,57 +19,365 @@ package minibroker
 import (
 	"fmt"
 	"net/url"
+	"strings"
 
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 )
 
-const redisProtocolName = "redis"
+const (
+	redisProtocolName    = "redis"
+	redisTLSProtocolName = "rediss"
+	// The scheme of the sentinel URIs, as understood by e.g. Lettuce and redis-py.
+	redisSentinelProtocolName    = "redis-sentinel"
+	redisTLSSentinelProtocolName = "rediss-sentinel"
+	defaultRedisMasterSet        = "mymaster"
+
+	// The binding parameter selecting the Redis 6 ACL user of the credentials, among the ones listed
+	// under auth.acl.users.
+	redisACLUserParam = "username"
+)
+
+// The Redis deployment topologies, as listed in the credentials.
+const (
+	redisStandalone  = "standalone"
+	redisReplication = "replication"
+	redisSentinel    = "sentinel"
+	redisCluster     = "cluster"
+)
+
+var (
+	// The names of the Redis ports across chart versions.
+	redisPortNames = []string{"tcp-redis", "redis"}
+	// The names of the Sentinel ports across chart versions.
+	redisSentinelPortNames = []string{"tcp-sentinel", "redis-sentinel", "sentinel"}
+)
+
+// redisTLS are the TLS parameters of the redis and redis-cluster charts. Provisioning requires
+// tls.authClients to be disabled, as clients otherwise present a certificate.
+var redisTLS = tlsParams{
+	enabled:         []string{"tls.enabled"},
+	existingSecret:  []string{"tls.existingSecret", "tls.certificatesSecret"},
+	caCert:          bitnamiTLSCaCert,
+	mutual:          []string{"tls.authClients"},
+	mutualByDefault: true,
+}
 
 type RedisProvider struct {
 	hostBuilder
 }
 
+// redisServices are the services of a Redis release, by role.
+type redisServices struct {
+	master   *corev1.Service
+	replica  *corev1.Service
+	sentinel *corev1.Service
+	cluster  *corev1.Service
+	headless *corev1.Service
+}
+
+// topology returns the topology of the deployment, or an empty string if no service clients can
+// connect to is found.
+func (s *redisServices) topology() string {
+	switch {
+	case s.cluster != nil:
+		return redisCluster
+	case s.sentinel != nil:
+		return redisSentinel
+	case s.master != nil && s.replica != nil:
+		return redisReplication
+	case s.master != nil:
+		return redisStandalone
+	}
+	return ""
+}
+
+// classifyRedisServices sorts the services of a release by their role. The roles are told by the
+// selectors of older (role) and newer (app.kubernetes.io/component) charts, the Sentinel ports and
+// the labels of the redis-cluster chart. A single service without a role, as created by some charts
+// in standalone mode, is the master.
+func classifyRedisServices(services []corev1.Service) *redisServices {
+	classified := &redisServices{}
+	var unlabeled []*corev1.Service
+	for i := range services {
+		svc := &services[i]
+		if svc.Spec.ClusterIP == corev1.ClusterIPNone {
+			classified.headless = svc
+			continue
+		}
+		if isRedisClusterService(svc) {
+			classified.cluster = svc
+			continue
+		}
+		if _, ok := findServicePort(svc, redisSentinelPortNames); ok {
+			classified.sentinel = svc
+			continue
+		}
+		role, ok := svc.Spec.Selector["app.kubernetes.io/component"]
+		if !ok {
+			role = svc.Spec.Selector["role"]
+		}
+		switch role {
+		case "master":
+			classified.master = svc
+		case "replica", "slave":
+			classified.replica = svc
+		default:
+			unlabeled = append(unlabeled, svc)
+		}
+	}
+	if classified.master == nil && classified.replica == nil && len(unlabeled) == 1 {
+		classified.master = unlabeled[0]
+	}
+	return classified
+}
+
+func isRedisClusterService(svc *corev1.Service) bool {
+	return svc.Labels["app.kubernetes.io/name"] == "redis-cluster" || svc.Labels["app"] == "redis-cluster"
+}
+
+// findServicePort returns the first port of a service named after any of names.
+func findServicePort(svc *corev1.Service, names []string) (corev1.ServicePort, bool) {
+	for _, name := range names {
+		for _, port := range svc.Spec.Ports {
+			if port.Name == name {
+				return port, true
+			}
+		}
+	}
+	return corev1.ServicePort{}, false
+}
+
+// redisPort returns the Redis port of a service, defaulting to its first port.
+func redisPort(svc *corev1.Service) (int32, error) {
+	if port, ok := findServicePort(svc, redisPortNames); ok {
+		return port.Port, nil
+	}
+	if len(svc.Spec.Ports) == 0 {
+		return 0, errors.Errorf("no ports found in service %s", svc.Name)
+	}
+	return svc.Spec.Ports[0].Port, nil
+}
+
 func (p RedisProvider) Bind(
 	services []corev1.Service,
-	_ *BindParams,
-	_ *ProvisionParams,
+	bindParams *BindParams,
+	provisionParams *ProvisionParams,
 	chartSecrets Object,
 ) (Object, error) {
-	var masterSvc *corev1.Service
-	for _, svc := range services {
-		if svc.Spec.Selector["role"] == "master" {
-			masterSvc = &svc
-			break
-		}
-	}
-	if masterSvc == nil {
+	return p.BindWithEndpoints(services, nil, bindParams, provisionParams, chartSecrets)
+}
+
+// BindWithEndpoints returns the credentials of an instance, listing the nodes of a Redis Cluster
+// from the Endpoints of its headless service.
+func (p RedisProvider) BindWithEndpoints(
+	services []corev1.Service,
+	endpoints []corev1.Endpoints,
+	bindParams *BindParams,
+	provisionParams *ProvisionParams,
+	chartSecrets Object,
+) (Object, error) {
+	classified := classifyRedisServices(services)
+	topology := classified.topology()
+	if topology == "" {
 		return nil, errors.New("could not identify the master service")
 	}
 
-	if len(masterSvc.Spec.Ports) == 0 {
-		return nil, errors.Errorf("no ports found")
+	username, password, err := redisCredentials(bindParams, provisionParams, chartSecrets)
+	if err != nil {
+		return nil, err
+	}
+	userInfo := url.UserPassword(username, password)
+	if password == "" {
+		userInfo = nil
 	}
-	svcPort := masterSvc.Spec.Ports[0]
+	creds := Object{
+		"protocol": redisProtocolName,
+		"topology": topology,
+		"password": password,
+	}
+	if username != "" {
+		creds["username"] = username
+	}
+	scheme, sentinelScheme := redisProtocolName, redisSentinelProtocolName
+	tlsEnabled, _, err := redisTLS.addCredentials(creds, provisionParams, chartSecrets)
+	if err != nil {
+		return nil, err
+	}
+	if tlsEnabled {
+		scheme, sentinelScheme = redisTLSProtocolName, redisTLSSentinelProtocolName
+	}
+	redisURI := func(host string, port int32) string {
+		return (&url.URL{
+			Scheme: scheme,
+			User:   userInfo,
+			Host:   fmt.Sprintf("%s:%d", host, port),
+		}).String()
+	}
+
+	var primary *corev1.Service
+	switch topology {
+	case redisCluster:
+		primary = classified.cluster
+	case redisSentinel:
+		primary = classified.sentinel
+	default:
+		primary = classified.master
+	}
+	host := p.hostFromService(primary)
+	port, err := redisPort(primary)
+	if err != nil {
+		return nil, err
+	}
+
+	creds["host"] = host
+	creds["port"] = port
+	creds["uri"] = redisURI(host, port)
 
-	host := p.hostFromService(masterSvc)
+	switch topology {
+	case redisReplication:
+		replicaHost := p.hostFromService(classified.replica)
+		replicaPort, err := redisPort(classified.replica)
+		if err != nil {
+			return nil, err
+		}
+		creds["replica_host"] = replicaHost
+		creds["replica_port"] = replicaPort
+		creds["replica_uri"] = redisURI(replicaHost, replicaPort)
+	case redisSentinel:
+		sentinelPort, _ := findServicePort(classified.sentinel, redisSentinelPortNames)
+		masterSet, err := provisionParams.DigStringOr("sentinel.masterSet", defaultRedisMasterSet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the sentinel master set: %w", err)
+		}
+		creds["sentinel_host"] = host
+		creds["sentinel_port"] = sentinelPort.Port
+		creds["master_set"] = masterSet
+		creds["sentinel_uri"] = (&url.URL{
+			Scheme:   sentinelScheme,
+			User:     userInfo,
+			Host:     fmt.Sprintf("%s:%d", host, sentinelPort.Port),
+			Fragment: masterSet,
+		}).String()
+	case redisCluster:
+		nodes, err := p.redisClusterNodes(classified.headless, endpoints, port)
+		if err != nil {
+			return nil, err
+		}
+		creds["nodes"] = nodes
+	}
 
+	return creds, nil
+}
+
+// redisClusterNodes returns the addresses of the nodes of a Redis Cluster, i.e. the pods of its
+// StatefulSet, listed by the Endpoints of its headless service.
+func (p RedisProvider) redisClusterNodes(headless *corev1.Service, endpoints []corev1.Endpoints, port int32) ([]string, error) {
+	if headless == nil {
+		return nil, errors.New("could not identify the headless service of the cluster")
+	}
+	hosts := p.hostsFromEndpoints(headless, endpoints)
+	if len(hosts) == 0 {
+		return nil, errors.Errorf("no nodes found in the endpoints of service %s", headless.Name)
+	}
+	nodes := make([]string, len(hosts))
+	for i, host := range hosts {
+		nodes[i] = fmt.Sprintf("%s:%d", host, port)
+	}
+	return nodes, nil
+}
+
+// redisCredentials returns the username and password of the credentials: the ACL user requested by
+// the binding parameters, or the default user. The password is empty when authentication is
+// disabled.
+func redisCredentials(bindParams *BindParams, provisionParams *ProvisionParams, chartSecrets Object) (string, string, error) {
+	if username, err := bindParams.DigString(redisACLUserParam); err != ErrDigNotFound {
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get the ACL username: %w", err)
+		}
+		return redisACLUser(username, provisionParams)
+	}
 	password, err := chartSecrets.DigString("redis-password")
+	if err == ErrDigNotFound && !redisAuthEnabled(provisionParams) {
+		return "", "", nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get password: %w", err)
+		return "", "", fmt.Errorf("failed to get password: %w", err)
 	}
+	return "", password, nil
+}
 
-	creds := Object{
-		"protocol": redisProtocolName,
-		"port":     svcPort.Port,
-		"host":     host,
-		"password": password,
-		"uri": (&url.URL{
-			Scheme: redisProtocolName,
-			User:   url.UserPassword("", password),
-			Host:   fmt.Sprintf("%s:%d", host, svcPort.Port),
-		}).String(),
+// redisAuthEnabled returns whether the instance requires a password, as set by auth.enabled in
+// newer chart versions and usePassword in older ones.
+func redisAuthEnabled(provisionParams *ProvisionParams) bool {
+	for _, key := range []string{"auth.enabled", "usePassword"} {
+		if enabled, ok := provisionParams.Dig(key); ok {
+			return enabled != false
+		}
+	}
+	return true
+}
+
+// redisACLUser returns the password of an ACL user listed under auth.acl.users. The password must be
+// set there, as the secrets of the chart don't hold it.
+func redisACLUser(username string, provisionParams *ProvisionParams) (string, string, error) {
+	users, _ := provisionParams.Dig("auth.acl.users")
+	list, _ := users.([]interface{})
+	for _, entry := range list {
+		user, ok := entry.(map[string]interface{})
+		if !ok || user["username"] != username {
+			continue
+		}
+		if password, ok := user["password"].(string); ok && password != "" {
+			return username, password, nil
+		}
+		return "", "", errors.Errorf("ACL user %q has no password in auth.acl.users", username)
 	}
+	return "", "", errors.Errorf("ACL user %q not found in auth.acl.users", username)
+}
 
-	return creds, nil
+// ReferencedSecrets returns the existing secret holding the TLS certificates, if any.
+func (p RedisProvider) ReferencedSecrets(provisionParams *ProvisionParams) []string {
+	return redisTLS.referencedSecrets(provisionParams)
+}
+
+// Properties returns the Spring Boot properties of the credentials, for the topology of the
+// instance.
+func (p RedisProvider) Properties(creds Object) (Object, error) {
+	properties := Object{"spring.redis.password": creds["password"]}
+	if username, ok := creds["username"]; ok {
+		properties["spring.redis.username"] = username
+	}
+	if creds[tlsCredential] == true {
+		properties["spring.redis.ssl"] = true
+	}
+	switch creds["topology"] {
+	case redisSentinel:
+		properties["spring.redis.sentinel.master"] = creds["master_set"]
+		properties["spring.redis.sentinel.nodes"] = fmt.Sprintf("%s:%v", creds["sentinel_host"], creds["sentinel_port"])
+	case redisCluster:
+		nodes, _ := creds["nodes"].([]string)
+		properties["spring.redis.cluster.nodes"] = strings.Join(nodes, ",")
+	default:
+		properties["spring.redis.host"] = creds["host"]
+		properties["spring.redis.port"] = creds["port"]
+	}
+	return properties, nil
+}
+
+// ValidateProvisionParams checks the parameters read when binding, and that TLS doesn't require
+// client certificates.
+func (p RedisProvider) ValidateProvisionParams(provisionParams *ProvisionParams) []FieldError {
+	fieldErrors := validateStringParams(provisionParams, "sentinel.masterSet")
+	return append(fieldErrors, redisTLS.validateMutual(provisionParams)...)
+}
+
+// ValidateBindParams checks the parameter selecting the ACL user.
+func (p RedisProvider) ValidateBindParams(bindParams *BindParams) []FieldError {
+	if username, ok := bindParams.Dig(redisACLUserParam); ok {
+		if s, isString := username.(string); !isString || s == "" {
+			return []FieldError{{Field: redisACLUserParam, Description: "must be a non-empty string"}}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,101 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	// The component names of the services in front of the primary, by chart version.
+	primaryComponents = map[string]bool{"master": true, "primary": true}
+	// The component names of the services in front of the read replicas, by chart version.
+	replicaComponents = map[string]bool{"slave": true, "secondary": true, "replica": true, "read": true}
+)
+
+// serviceComponent returns the component a service selects, as labelled by the charts: the
+// app.kubernetes.io/component label of newer chart versions, and component or role in older ones.
+func serviceComponent(svc *corev1.Service) string {
+	for _, key := range []string{"app.kubernetes.io/component", "component", "role"} {
+		if component, ok := svc.Spec.Selector[key]; ok {
+			return component
+		}
+	}
+	return ""
+}
+
+// primaryAndReplicaServices returns the services of a release clients connect to for writing and,
+// when replication is enabled, for reading. Headless and metrics services are left out. Without a
+// service labelled as the primary, the first remaining one is.
+func primaryAndReplicaServices(services []corev1.Service) (*corev1.Service, *corev1.Service, error) {
+	var primary, replica, unlabeled *corev1.Service
+	for i := range services {
+		svc := &services[i]
+		if svc.Spec.ClusterIP == corev1.ClusterIPNone || strings.HasSuffix(svc.Name, "-metrics") {
+			continue
+		}
+		component := serviceComponent(svc)
+		switch {
+		case primaryComponents[component]:
+			if primary == nil {
+				primary = svc
+			}
+		case replicaComponents[component]:
+			if replica == nil {
+				replica = svc
+			}
+		case unlabeled == nil:
+			unlabeled = svc
+		}
+	}
+	if primary == nil {
+		primary = unlabeled
+	}
+	if primary == nil {
+		return nil, nil, errors.Errorf("no services to process")
+	}
+	if len(primary.Spec.Ports) == 0 {
+		return nil, nil, errors.Errorf("no ports found")
+	}
+	if replica != nil && len(replica.Spec.Ports) == 0 {
+		replica = nil
+	}
+	return primary, replica, nil
+}
+
+// addReadEndpoint adds the read_host, read_port and read_uri credentials of the read replicas, the
+// read_uri being the uri with the host swapped.
+func addReadEndpoint(creds Object, host string, port int32) error {
+	rawURI, err := creds.DigString("uri")
+	if err != nil {
+		return fmt.Errorf("failed to get the uri: %w", err)
+	}
+	uri, err := url.Parse(rawURI)
+	if err != nil {
+		return fmt.Errorf("failed to parse the uri: %v", err)
+	}
+	uri.Host = fmt.Sprintf("%s:%d", host, port)
+	creds["read_host"] = host
+	creds["read_port"] = port
+	creds["read_uri"] = uri.String()
+	return nil
+}
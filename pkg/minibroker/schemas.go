@@ -0,0 +1,242 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/xeipuuv/gojsonschema"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+	klog "k8s.io/klog/v2"
+)
+
+// schemaLoadConcurrency bounds the chart archives downloaded at once for their values schemas.
+const schemaLoadConcurrency = 8
+
+// chartLoader loads the chart archive of a chart version.
+type chartLoader func(chartVersion *repo.ChartVersion) (*chart.Chart, error)
+
+// valuesSchema is the JSON schema of the values of a chart, published as the plan schemas and
+// validating the provisioning parameters.
+type valuesSchema struct {
+	schema    map[string]interface{}
+	validator *gojsonschema.Schema
+	// The chart values the parameters are merged over before validating, as Helm does. Schemas
+	// supplied by the operator validate the parameters alone.
+	defaults map[string]interface{}
+}
+
+// newValuesSchema compiles a JSON schema.
+func newValuesSchema(schema, defaults map[string]interface{}) (*valuesSchema, error) {
+	validator, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %v", err)
+	}
+	return &valuesSchema{schema: schema, validator: validator, defaults: defaults}, nil
+}
+
+// validate validates the provisioning parameters, returning the invalid fields.
+func (s *valuesSchema) validate(params map[string]interface{}) ([]FieldError, error) {
+	values := params
+	if s.defaults != nil {
+		values = mergeValues(s.defaults, params)
+	}
+	result, err := s.validator.Validate(gojsonschema.NewGoLoader(values))
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate the provisioning parameters: %v", err)
+	}
+	var fieldErrors []FieldError
+	for _, resultErr := range result.Errors() {
+		fieldErrors = append(fieldErrors, FieldError{Field: resultErr.Field(), Description: resultErr.Description()})
+	}
+	return fieldErrors, nil
+}
+
+// chartVersionKey identifies a chart version in the schema cache.
+func chartVersionKey(chartVersion *repo.ChartVersion) string {
+	return chartVersion.Name + "@" + chartVersion.Version
+}
+
+// schemaCache holds the values schemas of chart versions, loading each one on first use. Chart
+// versions failing to load are retried on their next use.
+type schemaCache struct {
+	mu   sync.Mutex
+	load chartLoader
+	// The values schemas by chart version key; nil for chart versions without one.
+	schemas map[string]*valuesSchema
+}
+
+// newSchemaCache creates a new schemaCache loading the chart versions with load.
+func newSchemaCache(load chartLoader) *schemaCache {
+	return &schemaCache{
+		load:    load,
+		schemas: make(map[string]*valuesSchema),
+	}
+}
+
+// get returns the values schema of a chart version, or nil when it has none, loading it when it
+// isn't cached yet.
+func (s *schemaCache) get(chartVersion *repo.ChartVersion) (*valuesSchema, error) {
+	key := chartVersionKey(chartVersion)
+	if schema, ok := s.cached(key); ok {
+		return schema, nil
+	}
+	// The lock isn't held while downloading, so that chart versions are loaded concurrently.
+	schema, err := loadValuesSchema(chartVersion, s.load)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schemas[key] = schema
+	return schema, nil
+}
+
+// cached returns the values schema of the chart version key, if loaded.
+func (s *schemaCache) cached(key string) (*valuesSchema, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schema, ok := s.schemas[key]
+	return schema, ok
+}
+
+// addPlanSchemas sets the operator-supplied schemas of the chart values, by chart name, and
+// publishes them as the plan schemas. The values.schema.json of the other chart versions are
+// loaded with load when first validating provisioning parameters, or by loadPlanSchemas; nothing
+// is downloaded here.
+func (c *catalog) addPlanSchemas(schemas map[string]map[string]interface{}, load chartLoader) error {
+	c.chartSchemas = make(map[string]*valuesSchema, len(schemas))
+	for chart, schema := range schemas {
+		s, err := newValuesSchema(schema, nil)
+		if err != nil {
+			return fmt.Errorf("invalid catalog: parameter schema of chart %q: %v", chart, err)
+		}
+		c.chartSchemas[chart] = s
+	}
+	c.valuesSchemas = newSchemaCache(load)
+	c.services = c.withPlanSchemas()
+	return nil
+}
+
+// loadPlanSchemas loads the values.schema.json of the chart versions backing the plans, and returns
+// the next generation of the catalog publishing them. The catalog itself is left untouched, as it
+// may be served meanwhile. Chart versions failing to load are logged and their plans left without
+// schemas.
+func (c *catalog) loadPlanSchemas() *catalog {
+	toLoad := make(map[string]*repo.ChartVersion)
+	for _, svc := range c.services {
+		for _, p := range svc.Plans {
+			plan := c.plans[p.ID]
+			if _, ok := c.chartSchemas[plan.chart]; !ok {
+				toLoad[chartVersionKey(plan.chartVersion)] = plan.chartVersion
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, schemaLoadConcurrency)
+	for key, chartVersion := range toLoad {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string, chartVersion *repo.ChartVersion) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if _, err := c.valuesSchemas.get(chartVersion); err != nil {
+				klog.Warningf("minibroker: leaving the plans of %s without schemas: %v", key, err)
+			}
+		}(key, chartVersion)
+	}
+	wg.Wait()
+
+	loaded := *c
+	loaded.generation++
+	loaded.services = c.withPlanSchemas()
+	return &loaded
+}
+
+// withPlanSchemas returns a copy of the services whose plans publish the operator-supplied schemas
+// and the values schemas loaded so far. The schemas validate the provisioning parameters; as
+// updating instances isn't supported, no update schema is published.
+func (c *catalog) withPlanSchemas() []osb.Service {
+	services := make([]osb.Service, len(c.services))
+	for i, service := range c.services {
+		plans := make([]osb.Plan, len(service.Plans))
+		for j, plan := range service.Plans {
+			if schema, ok := c.loadedSchema(c.plans[plan.ID]); ok {
+				plan.ParameterSchemas = &osb.ParameterSchemas{
+					ServiceInstances: &osb.ServiceInstanceSchema{
+						Create: &osb.InputParameters{Parameters: schema.schema},
+					},
+				}
+			}
+			plans[j] = plan
+		}
+		service.Plans = plans
+		services[i] = service
+	}
+	return services
+}
+
+// loadedSchema returns the schema of the values of a plan, if any, without loading it.
+func (c *catalog) loadedSchema(plan catalogPlan) (*valuesSchema, bool) {
+	if schema, ok := c.chartSchemas[plan.chart]; ok {
+		return schema, true
+	}
+	schema, _ := c.valuesSchemas.cached(chartVersionKey(plan.chartVersion))
+	return schema, schema != nil
+}
+
+// schema returns the schema of the values of a plan, if any, loading the chart version backing the
+// plan when needed. Chart versions failing to load are logged and left without schemas.
+func (c *catalog) schema(plan catalogPlan) (*valuesSchema, bool) {
+	if schema, ok := c.chartSchemas[plan.chart]; ok {
+		return schema, true
+	}
+	schema, err := c.valuesSchemas.get(plan.chartVersion)
+	if err != nil {
+		klog.Warningf("minibroker: not validating against the values schema of %s: %v", chartVersionKey(plan.chartVersion), err)
+		return nil, false
+	}
+	return schema, schema != nil
+}
+
+// loadValuesSchema loads the values.schema.json of a chart version, returning nil when the chart
+// has none.
+func loadValuesSchema(chartVersion *repo.ChartVersion, load chartLoader) (*valuesSchema, error) {
+	chrt, err := load(chartVersion)
+	if err != nil {
+		return nil, err
+	}
+	if len(chrt.Schema) == 0 {
+		return nil, nil
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(chrt.Schema, &schema); err != nil {
+		return nil, fmt.Errorf("invalid values.schema.json: %v", err)
+	}
+	defaults := chrt.Values
+	if defaults == nil {
+		defaults = map[string]interface{}{}
+	}
+	return newValuesSchema(schema, defaults)
+}
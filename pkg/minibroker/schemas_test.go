@@ -0,0 +1,213 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestLoadPlanSchemas(t *testing.T) {
+	tiers := map[string][]Tier{"redis": {{Name: "small"}, {Name: "large"}}}
+	catalog, err := buildCatalog(testCharts(), nil, tiers, "", offerAll, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+
+	var mu sync.Mutex
+	loads := make(map[string]int)
+	load := func(chartVersion *repo.ChartVersion) (*chart.Chart, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		loads[chartVersionKey(chartVersion)]++
+		switch chartVersion.Version {
+		case "10.5.7":
+			return &chart.Chart{Schema: []byte(`{"type": "object", "required": ["password"]}`)}, nil
+		case "9.0.0":
+			return &chart.Chart{}, nil
+		default:
+			return nil, fmt.Errorf("chart unavailable")
+		}
+	}
+	if err := catalog.addPlanSchemas(nil, load); err != nil {
+		t.Fatalf("addPlanSchemas: unexpected error: %v", err)
+	}
+	if len(loads) != 0 {
+		t.Errorf("addPlanSchemas: expected no chart to be loaded, actual %v", loads)
+	}
+
+	loaded := catalog.loadPlanSchemas()
+	expectedLoads := map[string]int{"redis@10.5.7": 1, "redis@9.0.0": 1, "wordpress@9.0.3": 1}
+	if !reflect.DeepEqual(loads, expectedLoads) {
+		t.Errorf("loadPlanSchemas: expected each chart version to be loaded once, actual %v", loads)
+	}
+	if loaded.generation != catalog.generation+1 {
+		t.Errorf("loadPlanSchemas: expected generation %d, actual %d", catalog.generation+1, loaded.generation)
+	}
+
+	schema := map[string]interface{}{"type": "object", "required": []interface{}{"password"}}
+	expected := &osb.ParameterSchemas{
+		ServiceInstances: &osb.ServiceInstanceSchema{
+			Create: &osb.InputParameters{Parameters: schema},
+		},
+	}
+	redis := loaded.services[0]
+	for _, plan := range redis.Plans[:2] {
+		if !reflect.DeepEqual(plan.ParameterSchemas, expected) {
+			t.Errorf("loadPlanSchemas: expected plan %s to have the schemas %+v, actual %+v", plan.Name, expected, plan.ParameterSchemas)
+		}
+	}
+	for _, plan := range append(redis.Plans[2:], loaded.services[1].Plans...) {
+		if plan.ParameterSchemas != nil {
+			t.Errorf("loadPlanSchemas: expected plan %s to have no schemas, actual %+v", plan.Name, plan.ParameterSchemas)
+		}
+	}
+	for _, service := range catalog.services {
+		for _, plan := range service.Plans {
+			if plan.ParameterSchemas != nil {
+				t.Errorf("loadPlanSchemas: expected the served catalog to be left untouched, actual %+v", plan.ParameterSchemas)
+			}
+		}
+	}
+}
+
+func TestCatalogSchemaLoadsLazily(t *testing.T) {
+	catalog, err := buildCatalog(testCharts(), nil, nil, "", offerAll, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+	var loads int
+	err = catalog.addPlanSchemas(nil, func(*repo.ChartVersion) (*chart.Chart, error) {
+		loads++
+		return &chart.Chart{Schema: []byte(`{"type": "object"}`)}, nil
+	})
+	if err != nil {
+		t.Fatalf("addPlanSchemas: unexpected error: %v", err)
+	}
+
+	plan, ok := catalog.plan("redis", "redis-5-0-7")
+	if !ok {
+		t.Fatalf("catalog.plan(redis, redis-5-0-7): expected the legacy plan")
+	}
+	for i := 0; i < 2; i++ {
+		if s, ok := catalog.schema(plan); !ok || !reflect.DeepEqual(s.schema, map[string]interface{}{"type": "object"}) {
+			t.Errorf("catalog.schema(redis-5-0-7): expected the chart schema, actual %v", s)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("catalog.schema: expected the chart version to be loaded once, actual %d", loads)
+	}
+}
+
+func TestAddPlanSchemasFromConfig(t *testing.T) {
+	catalog, err := buildCatalog(testCharts(), nil, nil, "", offerAll, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+
+	schema := map[string]interface{}{"type": "object"}
+	err = catalog.addPlanSchemas(map[string]map[string]interface{}{"redis": schema}, func(chartVersion *repo.ChartVersion) (*chart.Chart, error) {
+		if chartVersion.Name == "redis" {
+			t.Errorf("addPlanSchemas: unexpected load of %s", chartVersionKey(chartVersion))
+		}
+		return &chart.Chart{}, nil
+	})
+	if err != nil {
+		t.Fatalf("addPlanSchemas: unexpected error: %v", err)
+	}
+
+	for _, plan := range catalog.services[0].Plans {
+		if plan.ParameterSchemas == nil || !reflect.DeepEqual(plan.ParameterSchemas.ServiceInstances.Create.Parameters, schema) {
+			t.Errorf("addPlanSchemas: expected plan %s to have the configured schema, actual %+v", plan.Name, plan.ParameterSchemas)
+		}
+	}
+	if plan, ok := catalog.plan("redis", "redis-5-0-7"); !ok {
+		t.Errorf("catalog.plan(redis, redis-5-0-7): expected the legacy plan")
+	} else if s, ok := catalog.schema(plan); !ok || !reflect.DeepEqual(s.schema, schema) {
+		t.Errorf("catalog.schema(redis-5-0-7): expected the configured schema, actual %v", s)
+	}
+}
+
+func TestAddPlanSchemasInvalidConfig(t *testing.T) {
+	catalog, err := buildCatalog(testCharts(), nil, nil, "", offerAll, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+
+	schemas := map[string]map[string]interface{}{"redis": {"type": 42}}
+	err = catalog.addPlanSchemas(schemas, func(*repo.ChartVersion) (*chart.Chart, error) { return &chart.Chart{}, nil })
+	expected := `invalid catalog: parameter schema of chart "redis": invalid JSON schema: `
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("addPlanSchemas: expected error starting with %q, actual %v", expected, err)
+	}
+}
+
+func TestValuesSchemaValidate(t *testing.T) {
+	schema := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"image"},
+		"properties": map[string]interface{}{
+			"replicas": map[string]interface{}{"type": "integer"},
+			"persistence": map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"size": map[string]interface{}{"type": "string"}},
+			},
+		},
+	}
+	defaults := map[string]interface{}{"image": "redis", "replicas": 1}
+
+	s, err := newValuesSchema(schema, defaults)
+	if err != nil {
+		t.Fatalf("newValuesSchema: unexpected error: %v", err)
+	}
+	fieldErrors, err := s.validate(map[string]interface{}{"replicas": 3})
+	if err != nil || len(fieldErrors) != 0 {
+		t.Errorf("validate: expected the parameters merged over the chart values to be valid, actual %v, %v", fieldErrors, err)
+	}
+	fieldErrors, err = s.validate(map[string]interface{}{
+		"replicas":    "three",
+		"persistence": map[string]interface{}{"size": 8},
+	})
+	if err != nil {
+		t.Fatalf("validate: unexpected error: %v", err)
+	}
+	var fields []string
+	for _, fieldErr := range fieldErrors {
+		fields = append(fields, fieldErr.Field)
+	}
+	sort.Strings(fields)
+	if expected := []string{"persistence.size", "replicas"}; !reflect.DeepEqual(fields, expected) {
+		t.Errorf("validate: expected errors for %v, actual %v", expected, fieldErrors)
+	}
+
+	s, err = newValuesSchema(schema, nil)
+	if err != nil {
+		t.Fatalf("newValuesSchema: unexpected error: %v", err)
+	}
+	fieldErrors, err = s.validate(map[string]interface{}{"replicas": 3})
+	if err != nil || len(fieldErrors) != 1 || fieldErrors[0].Field != "(root)" {
+		t.Errorf("validate: expected the parameters alone to miss the required image, actual %v, %v", fieldErrors, err)
+	}
+}
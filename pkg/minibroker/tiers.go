@@ -0,0 +1,66 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Tier is a named size of the plans of a service, e.g. small or large. Every plan of the service
+// is offered once per tier, with the tier values installed under the provisioning parameters.
+type Tier struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Values      map[string]interface{} `json:"values,omitempty"`
+}
+
+var tierNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// validateTiers checks that the tiers of a chart have unique names usable in plan names.
+func validateTiers(chart string, tiers []Tier) error {
+	names := make(map[string]bool, len(tiers))
+	for _, tier := range tiers {
+		if !tierNameRegexp.MatchString(tier.Name) {
+			return fmt.Errorf("tier %q of chart %q must consist of lower case alphanumeric characters or '-'", tier.Name, chart)
+		}
+		if names[tier.Name] {
+			return fmt.Errorf("tier %q of chart %q is defined more than once", tier.Name, chart)
+		}
+		names[tier.Name] = true
+	}
+	return nil
+}
+
+// mergeValues deep merges values over defaults, returning a new map. Nested maps are merged; any
+// other value set in values replaces the default.
+func mergeValues(defaults, values map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(defaults)+len(values))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range values {
+		if vMap, ok := v.(map[string]interface{}); ok {
+			if dMap, ok := merged[k].(map[string]interface{}); ok {
+				merged[k] = mergeValues(dMap, vMap)
+				continue
+			}
+		}
+		merged[k] = v
+	}
+	return merged
+}
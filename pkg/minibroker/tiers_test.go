@@ -0,0 +1,58 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeValues(t *testing.T) {
+	defaults := map[string]interface{}{
+		"replicas": 1,
+		"persistence": map[string]interface{}{
+			"size":         "8Gi",
+			"storageClass": "standard",
+		},
+		"resources": map[string]interface{}{
+			"requests": map[string]interface{}{"memory": "256Mi"},
+		},
+	}
+	values := map[string]interface{}{
+		"replicas":    3,
+		"persistence": map[string]interface{}{"size": "20Gi"},
+		"resources":   "none",
+		"database":    "app",
+	}
+	expected := map[string]interface{}{
+		"replicas": 3,
+		"persistence": map[string]interface{}{
+			"size":         "20Gi",
+			"storageClass": "standard",
+		},
+		"resources": "none",
+		"database":  "app",
+	}
+
+	merged := mergeValues(defaults, values)
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("mergeValues: expected %v, actual %v", expected, merged)
+	}
+	if defaults["persistence"].(map[string]interface{})["size"] != "8Gi" {
+		t.Errorf("mergeValues: expected the defaults to be left untouched, actual %v", defaults)
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The credentials of instances served over TLS.
+const (
+	tlsCredential    = "tls"
+	caCertCredential = "ca_cert"
+)
+
+// referencedCAKey is the only key read from the referenced secrets, which must be of type
+// kubernetes.io/tls, as cert-manager writes them.
+const referencedCAKey = "ca.crt"
+
+// SecretReferrer is implemented by the Providers reading secrets that are not part of the release,
+// e.g. existing secrets holding the TLS certificates, named in the provisioning parameters. Their
+// CA certificate is given to Bind along with the chart secrets, but is left out of the credentials.
+type SecretReferrer interface {
+	ReferencedSecrets(provisionParams *ProvisionParams) []string
+}
+
+// secretKeyParam is a parameter naming a key of the secret holding the certificates.
+type secretKeyParam struct {
+	param      string
+	defaultKey string
+}
+
+// key returns the key named by the parameter, or its default.
+func (p secretKeyParam) key(provisionParams *ProvisionParams) (string, error) {
+	if p.param == "" {
+		return p.defaultKey, nil
+	}
+	return provisionParams.DigStringOr(p.param, p.defaultKey)
+}
+
+// tlsParams are the provisioning parameters of a chart serving its clients over TLS. The
+// parameters are listed by chart version, the newest first.
+type tlsParams struct {
+	// The parameters enabling TLS.
+	enabled []string
+	// The parameters naming an existing secret holding the certificates, used instead of the secret
+	// generated by the chart.
+	existingSecret []string
+	// The key of the CA certificate in the secret generated by the chart.
+	caCert secretKeyParam
+	// The parameters requiring clients to present a certificate, and whether they do by default.
+	// Minibroker can't issue client certificates: mutual TLS instances are rejected when provisioned.
+	mutual          []string
+	mutualByDefault bool
+}
+
+// bitnamiTLSCaCert is the key of the CA certificate of the Bitnami charts.
+var bitnamiTLSCaCert = secretKeyParam{"tls.certCAFilename", "ca.crt"}
+
+// isEnabled returns whether the instance is served over TLS.
+func (t tlsParams) isEnabled(provisionParams *ProvisionParams) bool {
+	for _, key := range t.enabled {
+		if enabled, ok := provisionParams.Dig(key); ok {
+			return enabled == true
+		}
+	}
+	return false
+}
+
+// isMutual returns whether clients must present a certificate.
+func (t tlsParams) isMutual(provisionParams *ProvisionParams) bool {
+	for _, key := range t.mutual {
+		if mutual, ok := provisionParams.Dig(key); ok {
+			return mutual == true
+		}
+	}
+	return t.mutualByDefault
+}
+
+// validateMutual rejects the instances requiring client certificates when provisioning them, as
+// they could not be bound.
+func (t tlsParams) validateMutual(provisionParams *ProvisionParams) []FieldError {
+	if !t.isEnabled(provisionParams) || !t.isMutual(provisionParams) {
+		return nil
+	}
+	field := t.mutual[0]
+	for _, key := range t.mutual {
+		if _, ok := provisionParams.Dig(key); ok {
+			field = key
+			break
+		}
+	}
+	return []FieldError{{Field: field, Description: "must be false, as client certificates are not supported"}}
+}
+
+// referencedSecrets returns the existing secret holding the certificates, if any.
+func (t tlsParams) referencedSecrets(provisionParams *ProvisionParams) []string {
+	if !t.isEnabled(provisionParams) {
+		return nil
+	}
+	for _, key := range t.existingSecret {
+		if name, err := provisionParams.DigString(key); err == nil && name != "" {
+			return []string{name}
+		}
+	}
+	return nil
+}
+
+// addCredentials adds the TLS credentials of an instance served over TLS: the tls flag and the CA
+// certificate when known, read from the referenced secret, if any. It returns whether TLS is
+// enabled and whether the CA certificate is known, so that the URIs verify the server. Instances
+// requiring client certificates can't be bound, as the only certificate at hand is the one of the
+// server.
+func (t tlsParams) addCredentials(creds Object, provisionParams *ProvisionParams, chartSecrets Object) (bool, bool, error) {
+	if !t.isEnabled(provisionParams) {
+		return false, false, nil
+	}
+	if t.isMutual(provisionParams) {
+		return false, false, errors.Errorf("TLS requires client certificates, which are not supported: set %s to false", t.mutual[0])
+	}
+	creds[tlsCredential] = true
+
+	caKey := referencedCAKey
+	if len(t.referencedSecrets(provisionParams)) == 0 {
+		var err error
+		if caKey, err = t.caCert.key(provisionParams); err != nil {
+			return false, false, fmt.Errorf("failed to get the CA certificate key: %w", err)
+		}
+	}
+	caCert, hasCA := secretValue(chartSecrets, caKey)
+	if hasCA {
+		if block, _ := pem.Decode([]byte(caCert)); block == nil || block.Type != "CERTIFICATE" {
+			return false, false, errors.Errorf("%q does not hold a PEM certificate", caKey)
+		}
+		creds[caCertCredential] = caCert
+	}
+	return true, hasCA, nil
+}
+
+// caCertName returns a name identifying a CA certificate, under which the database drivers
+// verifying the admin connections register or store it.
+func caCertName(caCert string) string {
+	digest := sha256.Sum256([]byte(caCert))
+	return fmt.Sprintf("minibroker-ca-%x", digest[:8])
+}
+
+// secretValue returns the value of a key of the chart secrets. Unlike Dig, it doesn't split the
+// keys, as the file names held by secrets have dots, e.g. tls.crt.
+func secretValue(chartSecrets Object, key string) (string, bool) {
+	value, ok := chartSecrets[key].(string)
+	return value, ok && value != ""
+}
+
+// providerSecrets returns the secrets given to a Provider: the secrets of the release, along with
+// the secrets it references, if any.
+func (c *Client) providerSecrets(
+	ctx context.Context,
+	provider Provider,
+	namespace string,
+	provisionParams *ProvisionParams,
+	secrets []corev1.Secret,
+) ([]corev1.Secret, error) {
+	referrer, ok := provider.(SecretReferrer)
+	if !ok {
+		return secrets, nil
+	}
+	names := referrer.ReferencedSecrets(provisionParams)
+	if len(names) == 0 {
+		return secrets, nil
+	}
+	all := append([]corev1.Secret{}, secrets...)
+	for _, name := range names {
+		secret, err := c.coreClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(newKubernetesError(err), "could not get the secret %q", name)
+		}
+		// Any secret of the namespace can be named: only the CA certificate of TLS secrets is read.
+		if secret.Type != corev1.SecretTypeTLS {
+			return nil, badRequestErrorf("the secret %q is not of type %s", name, corev1.SecretTypeTLS)
+		}
+		referenced := corev1.Secret{ObjectMeta: secret.ObjectMeta, Type: secret.Type}
+		if caCert, ok := secret.Data[referencedCAKey]; ok {
+			referenced.Data = map[string][]byte{referencedCAKey: caCert}
+		}
+		all = append(all, referenced)
+	}
+	return all, nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError describes an invalid provisioning parameter.
+type FieldError struct {
+	// The path of the parameter, e.g. persistence.size.
+	Field       string
+	Description string
+}
+
+// InvalidParamsError lists the invalid provisioning parameters of a request.
+type InvalidParamsError struct {
+	Errors []FieldError
+}
+
+// Error satisfies the error interface.
+func (e *InvalidParamsError) Error() string {
+	return "invalid provisioning parameters: " + describeFieldErrors(e.Errors)
+}
+
+// describeFieldErrors joins the descriptions of the field errors.
+func describeFieldErrors(fieldErrors []FieldError) string {
+	descriptions := make([]string, 0, len(fieldErrors))
+	for _, fieldErr := range fieldErrors {
+		descriptions = append(descriptions, fmt.Sprintf("%s: %s", fieldErr.Field, fieldErr.Description))
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// ProvisionParamsValidator is implemented by the providers reading the provisioning parameters when
+// binding, so that parameters they can't read are rejected before the chart is installed.
+type ProvisionParamsValidator interface {
+	ValidateProvisionParams(provisionParams *ProvisionParams) []FieldError
+}
+
+// validateStringParams checks that each of the keys is a string, when set.
+func validateStringParams(provisionParams *ProvisionParams, keys ...string) []FieldError {
+	var fieldErrors []FieldError
+	for _, key := range keys {
+		if _, err := provisionParams.DigString(key); err == ErrDigNotString {
+			fieldErrors = append(fieldErrors, FieldError{Field: key, Description: "must be a string"})
+		}
+	}
+	return fieldErrors
+}
+
+// validateAliasedStringParams checks that each of the alternative keys of a parameter, e.g. the
+// keys used by older chart versions, is a string when set, and that they are not set to different
+// values.
+func validateAliasedStringParams(provisionParams *ProvisionParams, altKeys []string) []FieldError {
+	fieldErrors := validateStringParams(provisionParams, altKeys...)
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+	var setKey, setValue string
+	for _, key := range altKeys {
+		value, err := provisionParams.DigString(key)
+		if err != nil {
+			continue
+		}
+		if setKey == "" {
+			setKey, setValue = key, value
+		} else if value != setValue {
+			fieldErrors = append(fieldErrors, FieldError{Field: key, Description: fmt.Sprintf("conflicts with %s", setKey)})
+		}
+	}
+	return fieldErrors
+}
+
+// validateProvisionParams validates the provisioning parameters of a plan against the values schema
+// of its chart and the requirements of its provider.
+func (c *Client) validateProvisionParams(plan catalogPlan, provisionParams *ProvisionParams) error {
+	var fieldErrors []FieldError
+	if validator, ok := c.providers[plan.chart].(ProvisionParamsValidator); ok {
+		fieldErrors = append(fieldErrors, validator.ValidateProvisionParams(provisionParams)...)
+	}
+	if schema, ok := c.currentCatalog().schema(plan); ok {
+		schemaErrors, err := schema.validate(provisionParams.Object)
+		if err != nil {
+			return err
+		}
+		fieldErrors = append(fieldErrors, schemaErrors...)
+	}
+	if len(fieldErrors) > 0 {
+		return newInvalidParamsError(fieldErrors)
+	}
+	return nil
+}
+
+// validateBindParams validates the binding parameters requesting credential formats, and the ones
+// read by the provider of a chart.
+func (c *Client) validateBindParams(chart string, bindParams *BindParams) error {
+	provider := c.providers[chart]
+	fieldErrors := validateCredentialFormatParams(provider, bindParams)
+	if validator, ok := provider.(BindParamsValidator); ok {
+		fieldErrors = append(fieldErrors, validator.ValidateBindParams(bindParams)...)
+	}
+	if len(fieldErrors) > 0 {
+		return badRequestErrorf("invalid binding parameters: %s", describeFieldErrors(fieldErrors))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package minibroker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPostgresProviderValidateProvisionParams(t *testing.T) {
+	tests := []struct {
+		params   map[string]interface{}
+		expected []FieldError
+	}{
+		{map[string]interface{}{}, nil},
+		{map[string]interface{}{"postgresqlDatabase": "app", "postgresDatabase": "app"}, nil},
+		{
+			map[string]interface{}{"postgresqlDatabase": 42, "postgresUsername": "app"},
+			[]FieldError{{Field: "postgresqlDatabase", Description: "must be a string"}},
+		},
+		{
+			map[string]interface{}{"postgresqlUsername": "app", "postgresUsername": "other"},
+			[]FieldError{{Field: "postgresUsername", Description: "conflicts with postgresqlUsername"}},
+		},
+	}
+
+	for _, tt := range tests {
+		actual := PostgresProvider{}.ValidateProvisionParams(NewProvisionParams(tt.params))
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("ValidateProvisionParams(%v): expected %v, actual %v", tt.params, tt.expected, actual)
+		}
+	}
+}
+
+func TestMariadbProviderValidateProvisionParams(t *testing.T) {
+	params := NewProvisionParams(map[string]interface{}{"db": map[string]interface{}{"name": "app", "user": true}})
+	expected := []FieldError{{Field: "db.user", Description: "must be a string"}}
+	if actual := (MariadbProvider{}).ValidateProvisionParams(params); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("ValidateProvisionParams: expected %v, actual %v", expected, actual)
+	}
+}
+
+func TestProvidersRejectMutualTLS(t *testing.T) {
+	tests := []struct {
+		provider ProvisionParamsValidator
+		params   map[string]interface{}
+		expected []FieldError
+	}{
+		{RedisProvider{}, map[string]interface{}{"tls": map[string]interface{}{"enabled": false}}, nil},
+		{RedisProvider{}, map[string]interface{}{"tls": map[string]interface{}{"enabled": true, "authClients": false}}, nil},
+		{
+			RedisProvider{},
+			map[string]interface{}{"tls": map[string]interface{}{"enabled": true}},
+			[]FieldError{{Field: "tls.authClients", Description: "must be false, as client certificates are not supported"}},
+		},
+		{
+			RabbitmqProvider{},
+			map[string]interface{}{"rabbitmq": map[string]interface{}{"tls": map[string]interface{}{"enabled": true, "failIfNoPeerCert": true}}},
+			[]FieldError{{Field: "rabbitmq.tls.failIfNoPeerCert", Description: "must be false, as client certificates are not supported"}},
+		},
+		{
+			MongodbProvider{},
+			map[string]interface{}{"tls": map[string]interface{}{"enabled": true, "mTLS": map[string]interface{}{"enabled": true}}},
+			[]FieldError{{Field: "tls.mTLS.enabled", Description: "must be false, as client certificates are not supported"}},
+		},
+		{MongodbProvider{}, map[string]interface{}{"tls": map[string]interface{}{"enabled": true, "mTLS": map[string]interface{}{"enabled": false}}}, nil},
+	}
+
+	for _, tt := range tests {
+		actual := tt.provider.ValidateProvisionParams(NewProvisionParams(tt.params))
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("ValidateProvisionParams(%T, %v): expected %v, actual %v", tt.provider, tt.params, tt.expected, actual)
+		}
+	}
+}
+
+func TestProvisionRejectsInvalidParams(t *testing.T) {
+	charts := map[string]repo.ChartVersions{
+		"postgresql": {testChartVersion("postgresql", "8.6.4", "11.7.0")},
+	}
+	catalog, err := buildCatalog(charts, nil, nil, "", offerAll, bindAll)
+	if err != nil {
+		t.Fatalf("buildCatalog: unexpected error: %v", err)
+	}
+	err = catalog.addPlanSchemas(nil, func(*repo.ChartVersion) (*chart.Chart, error) {
+		return &chart.Chart{
+			Schema: []byte(`{"type": "object", "properties": {"replicas": {"type": "integer"}}}`),
+			Values: map[string]interface{}{"replicas": 1},
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("addPlanSchemas: unexpected error: %v", err)
+	}
+	coreClient := fake.NewSimpleClientset()
+	client := &Client{
+		namespace:  "minibroker",
+		coreClient: coreClient,
+		providers:  map[string]Provider{"postgresql": PostgresProvider{}},
+		catalog:    catalog,
+	}
+
+	service := catalog.services[0]
+	params := NewProvisionParams(map[string]interface{}{"replicas": "two", "postgresqlDatabase": 42})
+	_, err = client.Provision("instance", service.ID, service.Plans[0].ID, "default", true, params, nil)
+
+	httpErr, ok := ToHTTPStatusCodeError(err).(osb.HTTPStatusCodeError)
+	if !ok || httpErr.StatusCode != http.StatusBadRequest || *httpErr.ErrorMessage != string(ErrorKindInvalidParameters) {
+		t.Fatalf("Provision: expected an InvalidParameters bad request, actual %v", err)
+	}
+	expected := "invalid provisioning parameters: postgresqlDatabase: must be a string; replicas: Invalid type. Expected: integer, given: string"
+	if *httpErr.Description != expected {
+		t.Errorf("Provision: expected the description %q, actual %q", expected, *httpErr.Description)
+	}
+	var invalidParams *InvalidParamsError
+	if !errors.As(err, &invalidParams) || len(invalidParams.Errors) != 2 {
+		t.Errorf("Provision: expected the field errors to be listed, actual %v", err)
+	}
+	configMaps, err := coreClient.CoreV1().ConfigMaps("minibroker").List(context.TODO(), metav1.ListOptions{})
+	if err != nil || len(configMaps.Items) != 0 {
+		t.Errorf("Provision: expected no instance ConfigMap to be created, actual %v, %v", configMaps, err)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tlsutil
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// versions maps the supported names of the minimum TLS version to their values.
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseVersion parses a TLS version in the "1.x" form.
+func ParseVersion(version string) (uint16, error) {
+	value, ok := versions[strings.TrimPrefix(strings.TrimSpace(version), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q", version)
+	}
+	return value, nil
+}
+
+// ParseCipherSuites parses the IANA names of cipher suites, e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Cipher suites with known security issues are refused.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	available := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// NewServerConfig creates the TLS configuration for the broker API server, serving the certificate
+// held by the reloader. When the reloader has a client CA bundle, client certificates are verified
+// against it; they are not required at the TLS level so that health checks can be served without
+// them, which is left to auth.RequireClientCertificate. An empty cipherSuites uses the Go defaults.
+func NewServerConfig(reloader *Reloader, minVersion uint16, cipherSuites []uint16) *tls.Config {
+	config := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if len(cipherSuites) > 0 {
+		config.CipherSuites = cipherSuites
+	}
+	if reloader.clientCAFile != "" {
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			clientConfig := config.Clone()
+			clientConfig.GetConfigForClient = nil
+			clientConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			clientConfig.ClientCAs = reloader.ClientCAs()
+			return clientConfig, nil
+		}
+	}
+	return config
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tlsutil_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/kubernetes-sigs/minibroker/pkg/tlsutil"
+)
+
+var _ = Describe("Config", func() {
+	Describe("ParseVersion", func() {
+		It("parses the supported versions", func() {
+			Expect(tlsutil.ParseVersion("1.2")).To(Equal(uint16(tls.VersionTLS12)))
+			Expect(tlsutil.ParseVersion("TLS1.3")).To(Equal(uint16(tls.VersionTLS13)))
+		})
+
+		It("fails for unknown versions", func() {
+			_, err := tlsutil.ParseVersion("1.4")
+			Expect(err).To(MatchError(`unsupported TLS version "1.4"`))
+		})
+	})
+
+	Describe("ParseCipherSuites", func() {
+		It("parses cipher suite names", func() {
+			Expect(tlsutil.ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", ""})).
+				To(Equal([]uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}))
+		})
+
+		It("refuses insecure cipher suites", func() {
+			_, err := tlsutil.ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+			Expect(err).To(MatchError(`unsupported cipher suite "TLS_RSA_WITH_RC4_128_SHA"`))
+		})
+	})
+
+	Describe("NewServerConfig", func() {
+		var (
+			dir    string
+			ca     *testCertificate
+			server *httptest.Server
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "minibroker-tlsutil")
+			Expect(err).ToNot(HaveOccurred())
+
+			ca = newTestCertificate("ca", nil)
+			serverCertificate := newTestCertificate("127.0.0.1", ca)
+			certFile := filepath.Join(dir, "tls.crt")
+			keyFile := filepath.Join(dir, "tls.key")
+			clientCAFile := filepath.Join(dir, "ca.crt")
+			Expect(ioutil.WriteFile(certFile, serverCertificate.certPEM, 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(keyFile, serverCertificate.keyPEM, 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(clientCAFile, ca.certPEM, 0600)).To(Succeed())
+
+			reloader, err := tlsutil.NewReloader(certFile, keyFile, clientCAFile)
+			Expect(err).ToNot(HaveOccurred())
+
+			server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(r.TLS.VerifiedChains) > 0 {
+					w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+				}
+			}))
+			server.TLS = tlsutil.NewServerConfig(reloader, tls.VersionTLS12, nil)
+			server.StartTLS()
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+		})
+
+		get := func(clientConfig *tls.Config) (string, error) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+			response, err := client.Get(server.URL)
+			if err != nil {
+				return "", err
+			}
+			defer response.Body.Close()
+			body, err := ioutil.ReadAll(response.Body)
+			return string(body), err
+		}
+
+		clientConfig := func(certificates ...tls.Certificate) *tls.Config {
+			rootCAs := x509.NewCertPool()
+			rootCAs.AddCert(ca.cert)
+			return &tls.Config{
+				RootCAs:      rootCAs,
+				ServerName:   "127.0.0.1",
+				Certificates: certificates,
+			}
+		}
+
+		It("verifies the client certificates signed by the client CA", func() {
+			client := newTestCertificate("client", ca)
+			certificate, err := tls.X509KeyPair(client.certPEM, client.keyPEM)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(get(clientConfig(certificate))).To(Equal("client"))
+		})
+
+		It("accepts connections without client certificates", func() {
+			Expect(get(clientConfig())).To(Equal(""))
+		})
+
+		It("doesn't verify client certificates signed by other CAs", func() {
+			client := newTestCertificate("client", newTestCertificate("other-ca", nil))
+			certificate, err := tls.X509KeyPair(client.certPEM, client.keyPEM)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(get(clientConfig(certificate))).To(Equal(""))
+		})
+
+		It("refuses TLS versions older than the minimum version", func() {
+			config := clientConfig()
+			config.MaxVersion = tls.VersionTLS11
+			_, err := get(config)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,141 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tlsutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	klog "k8s.io/klog/v2"
+)
+
+// Reloader holds a server certificate and an optional client CA bundle loaded from files, reloading
+// them when the files change.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	certPEM     []byte
+	keyPEM      []byte
+	clientCAPEM []byte
+}
+
+// NewReloader creates a new Reloader, loading the initial certificate and client CA bundle. The
+// clientCAFile is optional.
+func NewReloader(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, fmt.Errorf("failed to create TLS reloader: %v", err)
+	}
+	return r, nil
+}
+
+// Run reloads the files every interval until the context is done. When the files can't be loaded,
+// e.g. while they are partially written, the previous certificates are kept.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				klog.Errorf("tlsutil: %v", err)
+			}
+		}
+	}
+}
+
+// Reload reads the files, replacing the current certificate and client CA bundle if they changed.
+func (r *Reloader) Reload() error {
+	certPEM, err := ioutil.ReadFile(r.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to reload TLS certificate: %v", err)
+	}
+	keyPEM, err := ioutil.ReadFile(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to reload TLS certificate: %v", err)
+	}
+	var clientCAPEM []byte
+	if r.clientCAFile != "" {
+		if clientCAPEM, err = ioutil.ReadFile(r.clientCAFile); err != nil {
+			return fmt.Errorf("failed to reload client CA bundle: %v", err)
+		}
+	}
+
+	r.mu.RLock()
+	unchanged := bytes.Equal(certPEM, r.certPEM) &&
+		bytes.Equal(keyPEM, r.keyPEM) &&
+		bytes.Equal(clientCAPEM, r.clientCAPEM)
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("failed to reload TLS certificate: %v", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(clientCAPEM) {
+			return fmt.Errorf("failed to reload client CA bundle: no certificates found in %q", r.clientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certificate = &certificate
+	r.clientCAs = clientCAs
+	r.certPEM = certPEM
+	r.keyPEM = keyPEM
+	r.clientCAPEM = clientCAPEM
+	klog.V(1).Infof("tlsutil: loaded TLS certificate from %q", r.certFile)
+	return nil
+}
+
+// GetCertificate returns the current server certificate. It satisfies the
+// tls.Config.GetCertificate signature.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certificate, nil
+}
+
+// ClientCAs returns the current client CA bundle, or nil when client certificates are not
+// verified.
+func (r *Reloader) ClientCAs() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.clientCAs
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tlsutil_test
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/kubernetes-sigs/minibroker/pkg/tlsutil"
+)
+
+var _ = Describe("Reloader", func() {
+	var (
+		dir          string
+		certFile     string
+		keyFile      string
+		clientCAFile string
+		ca           *testCertificate
+	)
+
+	writeCertificate := func(certificate *testCertificate) {
+		Expect(ioutil.WriteFile(certFile, certificate.certPEM, 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(keyFile, certificate.keyPEM, 0600)).To(Succeed())
+	}
+
+	servedCommonName := func(reloader *tlsutil.Reloader) string {
+		certificate, err := reloader.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(certificate.Certificate).ToNot(BeEmpty())
+		parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+		Expect(err).ToNot(HaveOccurred())
+		return parsed.Subject.CommonName
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "minibroker-tlsutil")
+		Expect(err).ToNot(HaveOccurred())
+		certFile = filepath.Join(dir, "tls.crt")
+		keyFile = filepath.Join(dir, "tls.key")
+		clientCAFile = filepath.Join(dir, "ca.crt")
+
+		ca = newTestCertificate("ca", nil)
+		writeCertificate(newTestCertificate("server-1", ca))
+		Expect(ioutil.WriteFile(clientCAFile, ca.certPEM, 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("loads the certificate and the client CA bundle", func() {
+		reloader, err := tlsutil.NewReloader(certFile, keyFile, clientCAFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(servedCommonName(reloader)).To(Equal("server-1"))
+		Expect(reloader.ClientCAs()).ToNot(BeNil())
+	})
+
+	It("doesn't verify client certificates without a client CA bundle", func() {
+		reloader, err := tlsutil.NewReloader(certFile, keyFile, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reloader.ClientCAs()).To(BeNil())
+	})
+
+	It("fails when the key doesn't match the certificate", func() {
+		Expect(ioutil.WriteFile(keyFile, newTestCertificate("other", ca).keyPEM, 0600)).To(Succeed())
+		_, err := tlsutil.NewReloader(certFile, keyFile, "")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reloads the certificate when the files change", func() {
+		reloader, err := tlsutil.NewReloader(certFile, keyFile, clientCAFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		writeCertificate(newTestCertificate("server-2", ca))
+		Expect(reloader.Reload()).To(Succeed())
+		Expect(servedCommonName(reloader)).To(Equal("server-2"))
+	})
+
+	It("keeps the previous certificate when the files are invalid", func() {
+		reloader, err := tlsutil.NewReloader(certFile, keyFile, clientCAFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(ioutil.WriteFile(certFile, []byte("partially written"), 0600)).To(Succeed())
+		Expect(reloader.Reload()).ToNot(Succeed())
+		Expect(servedCommonName(reloader)).To(Equal("server-1"))
+	})
+})